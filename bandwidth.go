@@ -0,0 +1,145 @@
+package quark
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// bandwidthChunk 限速时单次读取的上限，避免一次读取过多导致长时间等待
+const bandwidthChunk = 32 * 1024
+
+// BandwidthWindow 每天某个时间段内的带宽限制
+type BandwidthWindow struct {
+	// Start End 距当天0点（本地时间）的时长，End 小于 Start 时跨越0点，如 22h 到 6h
+	Start time.Duration
+	End   time.Duration
+	// Limit 每秒字节数，<=0 表示该时间段不限制
+	Limit int64
+}
+
+func (w BandwidthWindow) contains(offset time.Duration) bool {
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// bandwidthLimiter 上传和下载共享的带宽限制，可在运行时调整
+type bandwidthLimiter struct {
+	mu       sync.Mutex
+	limit    int64
+	schedule []BandwidthWindow
+	bucket   *tokenBucket
+	now      func() time.Time
+}
+
+func newBandwidthLimiter(limit int64, schedule []BandwidthWindow) *bandwidthLimiter {
+	return &bandwidthLimiter{limit: limit, schedule: schedule, now: time.Now}
+}
+
+// rate 返回当前生效的限制，第一个包含当前时间的时间段优先，都不包含时使用limit
+func (l *bandwidthLimiter) rate() int64 {
+	now := l.now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+	for _, window := range l.schedule {
+		if window.contains(offset) {
+			return window.Limit
+		}
+	}
+	return l.limit
+}
+
+// wait 消耗n个字节的额度，超过限制时阻塞直到额度足够或ctx取消
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	rate := l.rate()
+	if rate <= 0 {
+		l.bucket = nil
+		l.mu.Unlock()
+		return nil
+	}
+	// 限制变化时重新计算，桶容量为1秒的额度
+	if l.bucket == nil || l.bucket.rate != float64(rate) {
+		l.bucket = newTokenBucket(float64(rate), int(rate))
+	}
+	bucket := l.bucket
+	l.mu.Unlock()
+	return sleepCtx(ctx, bucket.reserve(float64(n)))
+}
+
+func (l *bandwidthLimiter) setLimit(limit int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+}
+
+func (l *bandwidthLimiter) setSchedule(schedule []BandwidthWindow) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.schedule = schedule
+}
+
+func (l *bandwidthLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	return &limitedReader{Reader: r, ctx: ctx, limiter: l}
+}
+
+func (l *bandwidthLimiter) writer(ctx context.Context, w io.Writer) io.Writer {
+	return &limitedWriter{Writer: w, ctx: ctx, limiter: l}
+}
+
+type limitedReader struct {
+	io.Reader
+	ctx     context.Context
+	limiter *bandwidthLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > bandwidthChunk {
+		p = p[:bandwidthChunk]
+	}
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// limitedWriter 下载时限制写入速度，即限制读取响应的速度
+type limitedWriter struct {
+	io.Writer
+	ctx     context.Context
+	limiter *bandwidthLimiter
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if n > 0 {
+		if waitErr := w.limiter.wait(w.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// SetBandwidthLimit 调整上传和下载共享的带宽限制（每秒字节数），<=0 表示不限制，对进行中的传输立即生效
+func (c *QuarkClient) SetBandwidthLimit(limit int64) {
+	c.bandwidth.setLimit(limit)
+}
+
+// SetBandwidthSchedule 设置按时间段生效的带宽限制，不在任何时间段内时使用 SetBandwidthLimit 的值，
+// 如 BandwidthWindow{Start: 9 * time.Hour, End: 18 * time.Hour, Limit: 2 << 20} 表示工作时间限制为2MB/s
+func (c *QuarkClient) SetBandwidthSchedule(windows ...BandwidthWindow) {
+	c.bandwidth.setSchedule(windows)
+}
+
+// BandwidthLimit 返回当前生效的带宽限制，0 表示不限制
+func (c *QuarkClient) BandwidthLimit() int64 {
+	c.bandwidth.mu.Lock()
+	defer c.bandwidth.mu.Unlock()
+	return max(c.bandwidth.rate(), 0)
+}
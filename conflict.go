@@ -0,0 +1,135 @@
+package quark
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ConflictPolicy 上传时目标目录已存在同名文件的处理方式
+type ConflictPolicy int
+
+const (
+	// ConflictDefault 不检查同名文件，交给服务端处理
+	ConflictDefault ConflictPolicy = iota
+	// ConflictSkip 同名且大小一致时跳过，列表返回了MD5时还需MD5一致，否则照常上传
+	ConflictSkip
+	// ConflictOverwrite 先以临时文件名上传，成功后删除同名文件并重命名，上传失败时保留原文件
+	ConflictOverwrite
+	// ConflictRename 自动重命名为 name (1).ext
+	ConflictRename
+	// ConflictFail 返回 ErrNameConflict
+	ConflictFail
+)
+
+// dirFiles 获取目录下的文件，优先使用 FileId 遍历时缓存的列表
+func (c *QuarkClient) dirFiles(ctx context.Context, dirId string) ([]File, error) {
+	if files, found := c.dirCache.Get(dirId); found {
+		return files, nil
+	}
+	files, err := c.FileSortCtx(ctx, dirId)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		c.dirCache.Set(dirId, files)
+	}
+	return files, nil
+}
+
+// cacheUploaded 上传成功后把文件加入目录缓存，避免同一目录后续的冲突检查失效
+func (c *QuarkClient) cacheUploaded(dirId string, file File) {
+	c.updateDirCache(dirId, func(files []File) []File {
+		return append(files[:len(files):len(files)], file)
+	})
+}
+
+// updateDirCache 在 dirMu 保护下修改已缓存的目录列表，目录未缓存时不做任何事
+func (c *QuarkClient) updateDirCache(dirId string, update func(files []File) []File) {
+	c.dirMu.Lock()
+	defer c.dirMu.Unlock()
+	if files, found := c.dirCache.Get(dirId); found {
+		c.dirCache.Set(dirId, update(files))
+	}
+}
+
+// conflictResult 同名文件的处理结果
+type conflictResult struct {
+	// name 上传使用的文件名，覆盖时为临时文件名
+	name string
+	// skip 为true时不需要上传
+	skip bool
+	// replace 覆盖时被替换的文件fid，上传成功后才删除
+	replace string
+}
+
+// resolveConflict 按策略处理同名文件，hash为空时 ConflictSkip 只比较大小
+func (c *QuarkClient) resolveConflict(ctx context.Context, policy ConflictPolicy, dirId, name string, size int64, hash FileHash) (conflictResult, error) {
+	result := conflictResult{name: name}
+	if policy == ConflictDefault {
+		return result, nil
+	}
+	files, err := c.dirFiles(ctx, dirId)
+	if err != nil {
+		return result, err
+	}
+	names := make(map[string]File, len(files))
+	for _, file := range files {
+		names[file.FileName] = file
+	}
+	exist, ok := names[name]
+	if !ok {
+		return result, nil
+	}
+	switch policy {
+	case ConflictSkip:
+		result.skip = exist.File && int64(exist.Size) == size
+		// 列表返回了哈希时还需比较MD5
+		if result.skip && exist.Md5 != "" && hash.Md5 != "" {
+			result.skip = normalizeMd5(exist.Md5) == strings.ToLower(hash.Md5)
+		}
+		return result, nil
+	case ConflictOverwrite:
+		if !exist.File {
+			return result, fmt.Errorf("%w:%s is a directory", ErrNameConflict, name)
+		}
+		// 先以临时文件名上传，成功后再替换，上传失败时保留原文件。临时文件名固定，便于断点续传
+		ext := path.Ext(name)
+		result.name = fmt.Sprintf("%s.uploading-%s%s", strings.TrimSuffix(name, ext), md5Hash(exist.Fid)[:8], ext)
+		result.replace = exist.Fid
+		return result, nil
+	case ConflictRename:
+		ext := path.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		for i := 1; ; i++ {
+			newName := fmt.Sprintf("%s (%d)%s", base, i, ext)
+			if _, ok = names[newName]; !ok {
+				result.name = newName
+				return result, nil
+			}
+		}
+	default:
+		return result, fmt.Errorf("%w:%s", ErrNameConflict, name)
+	}
+}
+
+// replaceUploaded 删除被覆盖的文件，再把以临时文件名上传的文件重命名为name
+func (c *QuarkClient) replaceUploaded(ctx context.Context, dirId, oldFid, newFid, name string) error {
+	if err := c.FileDeleteCtx(ctx, []string{oldFid}); err != nil {
+		return fmt.Errorf("uploaded as temporary file %s but failed to delete the old file: %w", newFid, err)
+	}
+	c.updateDirCache(dirId, func(files []File) []File {
+		remain := make([]File, 0, len(files))
+		for _, file := range files {
+			if file.Fid != oldFid {
+				remain = append(remain, file)
+			}
+		}
+		return remain
+	})
+	if err := c.FileRenameCtx(ctx, newFid, name); err != nil {
+		return fmt.Errorf("old file deleted but failed to rename temporary file %s to %s: %w", newFid, name, err)
+	}
+	return nil
+}
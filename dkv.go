@@ -5,87 +5,111 @@ import (
 	"fmt"
 	"github.com/peterbourgon/diskv/v3"
 	"os"
-	"reflect"
-	"strconv"
+	"sort"
+	"sync"
+	"time"
 )
 
-var dkv *diskv.Diskv
+// diskvStore 基于diskv的 StateStore，每个key一个文件
+type diskvStore struct {
+	dkv *diskv.Diskv
+}
 
-func init() {
-	// 定义一个简单的转换函数，将所有数据文件放入基础目录。
-	// 使用提供的选项初始化一个新的diskv存储，根目录为从配置读出，缓存大小为10MB。
-	dkv = diskv.New(diskv.Options{
-		BasePath:     os.TempDir() + "/quark-cache",
+// NewDiskvStore 创建以dir为根目录的 StateStore，key会作为文件名，内存缓存大小为10MB
+func NewDiskvStore(dir string) StateStore {
+	return &diskvStore{dkv: diskv.New(diskv.Options{
+		BasePath:     dir,
 		CacheSizeMax: 10 * 1024 * 1024, // 10MB
-	})
+	})}
 }
 
-func GetCache[V any](key string, val V) error {
-	if dkv.Has(key) {
-		raw := dkv.ReadString(key)
+func (s *diskvStore) Get(key string) ([]byte, bool, error) {
+	entry, ok, err := s.read(key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if entry.expired() {
+		_ = s.Delete(key)
+		return nil, false, nil
+	}
+	return entry.Value, true, nil
+}
 
-		// 反射获取val的指针
-		valValue := reflect.ValueOf(val)
-		if valValue.Kind() != reflect.Ptr {
-			return fmt.Errorf("expected a pointer, got: %s", valValue.Kind())
-		}
+func (s *diskvStore) read(key string) (stateEntry, bool, error) {
+	var entry stateEntry
+	raw, err := s.dkv.Read(key)
+	if os.IsNotExist(err) {
+		return entry, false, nil
+	}
+	if err != nil {
+		return entry, false, err
+	}
+	if err = json.Unmarshal(raw, &entry); err != nil {
+		return entry, false, err
+	}
+	return entry, true, nil
+}
 
-		// 将string转换为目标类型
-		switch valValue.Elem().Kind() {
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
-			reflect.Float32, reflect.Float64,
-			reflect.String:
-			// 基础类型直接转换
-			if valValue.Elem().Kind() == reflect.String {
-				valValue.Elem().SetString(raw)
-			} else if valValue.Elem().Kind() == reflect.Int {
-				// 尝试将string转换为数值
-				num, err := strconv.ParseInt(raw, 10, 64)
-				if err != nil {
-					return err
-				}
-				valValue.Elem().SetInt(num)
-			} else {
-				// 尝试将string转换为数值
-				num, err := strconv.ParseFloat(raw, 64)
-				if err != nil {
-					return err
-				}
-				valValue.Elem().SetFloat(num)
-			}
-		default:
-			// 对于非基础类型，使用JSON转换
-			if valValue.Elem().Kind() == reflect.Struct {
-				err := json.Unmarshal([]byte(raw), val)
-				if err != nil {
-					return err
-				}
-			} else {
-				return fmt.Errorf("unsupported type: %s", valValue.Elem().Kind())
-			}
-		}
+func (s *diskvStore) Set(key string, value []byte, ttl time.Duration) error {
+	raw, err := json.Marshal(newStateEntry(value, ttl))
+	if err != nil {
+		return err
+	}
+	return s.dkv.Write(key, raw)
+}
+
+func (s *diskvStore) Delete(key string) error {
+	if !s.dkv.Has(key) {
 		return nil
 	}
-	return fmt.Errorf("key:%s not exist", key)
+	return s.dkv.Erase(key)
 }
 
-func SetCache(key string, value interface{}) error {
-	var val string
-	if v, ok := value.(string); ok {
-		val = v
-	} else if v, ok := value.(int64); ok {
-		val = strconv.FormatInt(v, 10)
-	} else {
-		marshal, err := json.Marshal(value)
+func (s *diskvStore) List(prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	for key := range s.dkv.KeysPrefix(prefix, nil) {
+		entry, ok, err := s.read(key)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		val = string(marshal)
+		if ok && !entry.expired() {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+var defaultCache = sync.OnceValue(defaultStateStore)
+
+// GetCache 读取默认状态存储中key的值并JSON解码到val，val必须为指针
+//
+// Deprecated: 使用 WithStateStore 配置的 StateStore
+func GetCache[V any](key string, val V) error {
+	raw, ok, err := defaultCache().Get(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("key:%s not exist", key)
+	}
+	return json.Unmarshal(raw, val)
+}
+
+// SetCache JSON编码value后写入默认状态存储，永不过期
+//
+// Deprecated: 使用 WithStateStore 配置的 StateStore
+func SetCache(key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
 	}
-	return dkv.WriteString(key, val)
+	return defaultCache().Set(key, raw, 0)
 }
 
+// DelCache 删除默认状态存储中的key
+//
+// Deprecated: 使用 WithStateStore 配置的 StateStore
 func DelCache(key string) error {
-	return dkv.Erase(key)
+	return defaultCache().Delete(key)
 }
@@ -1,6 +1,9 @@
 package quark
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 // Resp 基础序列化器
 type Resp struct {
@@ -128,6 +131,8 @@ type File struct {
 			PagesNumber       int     `json:"pages_number"`
 		} `json:"classifier_result"`
 	} `json:"pdf_info,omitempty"`
+	// Md5 列表接口返回文件哈希时有值，可能为hex或base64
+	Md5 string `json:"md5,omitempty"`
 }
 type Dir struct {
 	Finish bool   `json:"finish"`
@@ -184,6 +189,10 @@ type FileUpPreReq struct {
 	FileName string `json:"file_name"`
 	FileSize int64  `json:"file_size"`
 	MimeType string `json:"mime_type"`
+	// CreatedAt UpdatedAt 本地文件的创建和修改时间，网盘中保留为 l_created_at、l_updated_at。
+	// UpdatedAt 为零值时使用当前时间，CreatedAt 为零值时与 UpdatedAt 相同
+	CreatedAt time.Time `json:"l_created_at"`
+	UpdatedAt time.Time `json:"l_updated_at"`
 }
 
 type FileUpHashReq struct {
@@ -221,6 +230,15 @@ type FileUpCommitReq struct {
 	Callback  FileUpCallback `json:"callback"`
 }
 
+type FileUpAbortReq struct {
+	ObjKey    string `json:"obj_key"`
+	Bucket    string `json:"bucket"`
+	UploadId  string `json:"upload_id"`
+	AuthInfo  string `json:"auth_info"`
+	UploadUrl string `json:"upload_url"`
+	TaskId    string `json:"task_id"`
+}
+
 type FileUpAuth struct {
 	AuthKey string        `json:"auth_key"`
 	Speed   int           `json:"speed"`
@@ -385,6 +403,18 @@ type DownloadData struct {
 	} `json:"_private_extra"`
 }
 
+// OneStepUploadPathReq 上传目录的参数。
+//
+// 过滤规则中的路径均为相对于 LocalPath、以/分隔的路径，按以下顺序判断，先命中的规则生效：
+//  1. 目录依次判断 IgnorePaths、.quarkignore、ExcludeGlobs、ExcludeRegexps，命中时跳过整个目录，
+//     其下的文件不会再被任何规则重新包含
+//  2. 文件命中 .quarkignore、ExcludeGlobs、ExcludeRegexps、IgnoreFiles、IgnoreExtensions 任一时跳过
+//  3. IncludeGlobs、IncludeRegexps 不为空时，文件需命中其中至少一个
+//  4. Extensions 不为空时，文件需以其中之一结尾
+//  5. 文件大小需在 [MinSize, MaxSize] 内，修改时间需在 (ModifiedAfter, ModifiedBefore) 内
+//
+// .quarkignore 使用gitignore的语法：#注释、!取反、/结尾只匹配目录、包含/时相对于所在目录，
+// 否则匹配任意层级，**匹配多级目录。子目录的规则在上级目录之后应用，最后一条匹配的规则生效
 type OneStepUploadPathReq struct {
 	LocalPath        string
 	RemotePath       string
@@ -395,7 +425,47 @@ type OneStepUploadPathReq struct {
 	IgnoreFiles      []string
 	Extensions       []string
 	IgnoreExtensions []string
-	RemoteTransfer   func(remotePath, remoteName string) (string, string)
+	// IncludeGlobs ExcludeGlobs 匹配相对路径的glob，支持**，如 **/*.mp4、node_modules/**
+	IncludeGlobs []string
+	ExcludeGlobs []string
+	// IncludeRegexps ExcludeRegexps 匹配相对路径的正则表达式
+	IncludeRegexps []string
+	ExcludeRegexps []string
+	// MinSize MaxSize 文件大小的范围，0 表示不限制
+	MinSize int64
+	MaxSize int64
+	// ModifiedAfter ModifiedBefore 文件修改时间的范围，零值表示不限制
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	// NoIgnoreFile 不读取目录中的 .quarkignore
+	NoIgnoreFile   bool
+	RemoteTransfer func(remotePath, remoteName string) (string, string)
+	// Progress 接收每个文件的传输进度
+	Progress ProgressListener
+	// PartConcurrency 单个文件同时上传的分片数，0 使用服务端返回的 part_thread
+	PartConcurrency int
+	// HashCache 缓存文件的哈希，文件未变化时重复上传不再重新计算
+	HashCache bool
+	// ConflictPolicy 目标目录已存在同名文件时的处理方式
+	ConflictPolicy ConflictPolicy
+	// RapidOnly 只尝试秒传，不传输数据，秒传失败的文件通过 RapidMissCallback 通知
+	RapidOnly         bool
+	RapidMissCallback func(localFile string, size int64)
+	// Concurrency 同时上传的文件数，默认1。大于1时 Progress 和 RapidMissCallback 会被并发调用。
+	// 失败的文件汇总在 *UploadPathError 中返回，SkipFileErr 为false时第一个失败后不再上传新的文件
+	Concurrency int
+	// DryRun 只遍历、过滤并解析网盘路径，计划的上传、创建目录和删除记录在 UploadReport.Planned 中，
+	// 不发送任何修改请求，也不删除本地文件
+	DryRun bool
+	// VerifyBeforeDel SuccessDel 时先校验网盘文件的大小和MD5，不一致时保留本地文件并返回 ErrVerifyFailed
+	VerifyBeforeDel bool
+	// TrashDir SuccessDel 时把本地文件按原有目录结构移动到该目录而不是直接删除，
+	// 上级目录为空时逐级删除，但不会删除 LocalPath 本身
+	TrashDir string
+	// Symlinks 符号链接的处理方式，默认上传指向的文件并跳过指向目录的链接。
+	// 管道、套接字、设备等特殊文件总是跳过，结果为 OutcomeSkippedUnsupported；
+	// 没有读取权限的文件和目录记录为失败，即使 SkipFileErr 为false也不会中止遍历
+	Symlinks SymlinkPolicy
 }
 
 type OneStepUploadFileReq struct {
@@ -404,6 +474,79 @@ type OneStepUploadFileReq struct {
 	Resumable      bool
 	SuccessDel     bool
 	RemoteTransfer func(remoteName, remotePath string) (string, string)
+	// Progress 接收传输进度
+	Progress ProgressListener
+	// PartConcurrency 同时上传的分片数，0 使用服务端返回的 part_thread
+	PartConcurrency int
+	// HashCache 缓存文件的哈希，文件未变化时不再重新计算
+	HashCache bool
+	// ConflictPolicy 目标目录已存在同名文件时的处理方式
+	ConflictPolicy ConflictPolicy
+	// RapidOnly 只尝试秒传，秒传失败时返回 ErrRapidUploadMiss 且不传输数据
+	RapidOnly bool
+	// DryRun 只解析网盘路径并记录日志，不发送任何修改请求
+	DryRun bool
+	// VerifyBeforeDel SuccessDel 时先校验网盘文件的大小和MD5，不一致时保留本地文件并返回 ErrVerifyFailed
+	VerifyBeforeDel bool
+	// TrashDir SuccessDel 时把本地文件移动到该目录而不是直接删除
+	TrashDir string
+}
+
+type RapidUploadResult struct {
+	LocalFile  string
+	RemotePath string
+	Size       int64
+	// Hit 秒传成功，为false时需要真正上传
+	Hit bool
+	Fid string
+}
+
+type PendingUpload struct {
+	// Id 用于 AbortUpload
+	Id         string
+	LocalFile  string
+	RemotePath string
+	Size       int64
+	BytesDone  int64
+	// Age 距会话创建的时间
+	Age       time.Duration
+	UpdatedAt time.Time
+}
+
+type UploadReaderOpts struct {
+	// Md5 Sha1 预先计算好的哈希，都提供且数据源实现了 io.ReaderAt 时不会额外读取数据
+	Md5  string
+	Sha1 string
+	// MimeType 为空时根据文件名推断
+	MimeType        string
+	PartConcurrency int
+	Progress        ProgressListener
+	// SpoolMemory 非 io.ReaderAt 的数据源在内存中缓存的上限，超过时写入临时文件，默认32MB
+	SpoolMemory int64
+	// SpoolDir 临时文件目录，默认 os.TempDir()
+	SpoolDir string
+	// ModTime CreatedAt 网盘中记录的修改和创建时间，ModTime 为零值时使用当前时间，CreatedAt 为零值时与 ModTime 相同
+	ModTime   time.Time
+	CreatedAt time.Time
+}
+
+type OneStepDownloadFileReq struct {
+	Object    File
+	LocalPath string
+	Callback  DownloadCallback
+	// Progress 接收传输进度
+	Progress ProgressListener
+	// Concurrency 同时下载的分段数，默认1
+	Concurrency int
+	// SegmentSize 分段大小，默认250MB
+	SegmentSize int64
+	// TempDir 下载中的临时文件所在目录，默认 LocalPath。临时文件预分配为完整大小，已完成的分段记录在状态存储中，
+	// 中断后以相同参数重新下载时跳过已完成的分段
+	TempDir string
+	// VerifyRetries 下载完成后与网盘的大小和MD5不一致时重新下载的次数，默认0，仍不一致时返回 ErrChecksumMismatch
+	VerifyRetries int
+	// QuarantineDir 不一致的文件移动到该目录，为空时直接删除
+	QuarantineDir string
 }
 
 type DownloadCallback func(localPath, localFile string)
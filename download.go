@@ -0,0 +1,219 @@
+package quark
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// defaultSegmentSize 分段下载默认的分段大小
+const defaultSegmentSize = int64(250 * 1024 * 1024)
+
+// downloadJournal 分段下载的续传日志，记录预分配的临时文件和已完成的分段
+type downloadJournal struct {
+	Fid         string `json:"fid"`
+	Size        int64  `json:"size"`
+	Md5         string `json:"md5,omitempty"`
+	SegmentSize int64  `json:"segment_size"`
+	TempFile    string `json:"temp_file"`
+	// Segments 已完成的分段，key为分段序号，从0开始
+	Segments  map[int]bool `json:"segments"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+func journalKey(resumeKey string) string {
+	return "download_" + resumeKey
+}
+
+func newDownloadJournal(object File, md5 string, segmentSize int64, tempFile string) *downloadJournal {
+	now := time.Now()
+	return &downloadJournal{
+		Fid:         object.Fid,
+		Size:        int64(object.Size),
+		Md5:         md5,
+		SegmentSize: segmentSize,
+		TempFile:    tempFile,
+		Segments:    make(map[int]bool),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+// validate 检查日志能否用于续传，不能时返回原因
+func (j *downloadJournal) validate(expected *downloadJournal) error {
+	switch {
+	case j.Fid != expected.Fid:
+		return fmt.Errorf("fid changed from %s to %s", j.Fid, expected.Fid)
+	case j.Size != expected.Size:
+		return fmt.Errorf("size changed from %d to %d", j.Size, expected.Size)
+	case j.Md5 != "" && expected.Md5 != "" && j.Md5 != expected.Md5:
+		return fmt.Errorf("md5 changed from %s to %s", j.Md5, expected.Md5)
+	case j.SegmentSize != expected.SegmentSize:
+		return fmt.Errorf("segment size changed from %d to %d", j.SegmentSize, expected.SegmentSize)
+	case j.TempFile != expected.TempFile:
+		return fmt.Errorf("temp file changed from %s to %s", j.TempFile, expected.TempFile)
+	}
+	stat, err := os.Stat(j.TempFile)
+	if err != nil {
+		return err
+	}
+	if stat.Size() != j.Size {
+		return fmt.Errorf("temp file size %d, expected %d", stat.Size(), j.Size)
+	}
+	count := j.segmentCount()
+	for index := range j.Segments {
+		if index < 0 || index >= count {
+			return fmt.Errorf("invalid segment %d", index)
+		}
+	}
+	return nil
+}
+
+func (j *downloadJournal) segmentCount() int {
+	return int((j.Size + j.SegmentSize - 1) / j.SegmentSize)
+}
+
+// segment 返回第index个分段的起止位置，end包含在内
+func (j *downloadJournal) segment(index int) (int64, int64) {
+	start := int64(index) * j.SegmentSize
+	return start, min(start+j.SegmentSize, j.Size) - 1
+}
+
+// doneBytes 已完成分段的字节数
+func (j *downloadJournal) doneBytes() int64 {
+	var done int64
+	for index := range j.Segments {
+		start, end := j.segment(index)
+		done += end - start + 1
+	}
+	return done
+}
+
+// loadJournal 读取并校验续传日志，不存在或无效时返回expected并清理旧的状态
+func (c *QuarkClient) loadJournal(resumeKey string, expected *downloadJournal) *downloadJournal {
+	key := journalKey(resumeKey)
+	var j downloadJournal
+	ok, err := c.getState(key, &j)
+	if !ok {
+		c.logger.Debug("cache miss", "key", key, "fid", expected.Fid, "err", err)
+		return expected
+	}
+	if err = j.validate(expected); err != nil {
+		c.logger.Info("discard download journal", "fid", expected.Fid, "reason", err)
+		_ = c.delState(key)
+		return expected
+	}
+	if j.Segments == nil {
+		j.Segments = make(map[int]bool)
+	}
+	return &j
+}
+
+func (c *QuarkClient) saveJournal(resumeKey string, j *downloadJournal) {
+	j.UpdatedAt = time.Now()
+	if err := c.setState(journalKey(resumeKey), j, uploadStateTTL); err != nil {
+		c.logger.Warn("cache error", "key", journalKey(resumeKey), "fid", j.Fid, "err", err)
+	}
+}
+
+// openTempFile 续传时打开已有的临时文件，否则创建并预分配大小
+func openTempFile(j *downloadJournal) (*os.File, error) {
+	if len(j.Segments) > 0 {
+		return os.OpenFile(j.TempFile, os.O_WRONLY, 0)
+	}
+	file, err := os.Create(j.TempFile)
+	if err != nil {
+		return nil, err
+	}
+	if err = file.Truncate(j.Size); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// errSegmentOverflow 服务端返回的数据超出请求的范围
+var errSegmentOverflow = errors.New("response exceeds requested range")
+
+// errRangeUnsupported 服务端忽略了Range，返回了整个文件
+var errRangeUnsupported = errors.New("range not supported")
+
+// checkContentRange 检查206响应的Content-Range与请求的 [start, end] 是否一致
+func checkContentRange(value string, start, end int64) error {
+	var gotStart, gotEnd int64
+	var total string
+	if _, err := fmt.Sscanf(value, "bytes %d-%d/%s", &gotStart, &gotEnd, &total); err != nil {
+		return fmt.Errorf("invalid content range %q: %w", value, err)
+	}
+	if gotStart != start || gotEnd != end {
+		return fmt.Errorf("content range %q, requested %d-%d", value, start, end)
+	}
+	return nil
+}
+
+// segmentWriter 限制写入的长度，避免覆盖相邻分段的数据，并按实际写入的字节数更新进度
+type segmentWriter struct {
+	w         *io.OffsetWriter
+	remaining int64
+	tracker   *progressTracker
+}
+
+func (s *segmentWriter) Write(p []byte) (int, error) {
+	var overflow error
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+		overflow = errSegmentOverflow
+	}
+	n, err := s.w.Write(p)
+	s.remaining -= int64(n)
+	if n > 0 {
+		s.tracker.add(int64(n))
+	}
+	if err == nil {
+		err = overflow
+	}
+	return n, err
+}
+
+// fileMd5 计算文件的MD5
+func fileMd5(ctx context.Context, filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := md5.New()
+	if _, err = io.CopyBuffer(hasher, &ctxReader{ctx: ctx, r: file}, make([]byte, 1024*1024)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// verifyDownload 比较下载的文件与网盘返回的大小和MD5，网盘没有返回MD5时只比较大小
+func (c *QuarkClient) verifyDownload(ctx context.Context, path string, size int64, md5 string) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if stat.Size() != size {
+		return fmt.Errorf("%w: %s size %d, remote %d", ErrChecksumMismatch, path, stat.Size(), size)
+	}
+	if md5 == "" {
+		c.logger.Debug("remote md5 missing, verified by size only", "path", path)
+		return nil
+	}
+	local, err := fileMd5(ctx, path)
+	if err != nil {
+		return err
+	}
+	if local != normalizeMd5(md5) {
+		return fmt.Errorf("%w: %s md5 %s, remote %s", ErrChecksumMismatch, path, local, md5)
+	}
+	return nil
+}
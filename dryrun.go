@@ -0,0 +1,104 @@
+package quark
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// PlannedOpType 演练模式下计划执行的操作
+type PlannedOpType string
+
+const (
+	// PlanUpload 上传文件，Path为本地路径，Target为网盘路径
+	PlanUpload PlannedOpType = "upload"
+	// PlanMakeDir 创建目录，Path为目录名，Fids为父目录
+	PlanMakeDir PlannedOpType = "mkdir"
+	// PlanDelete 删除网盘文件
+	PlanDelete PlannedOpType = "delete"
+	// PlanMove 移动网盘文件到Target目录
+	PlanMove PlannedOpType = "move"
+	// PlanRename 重命名网盘文件为Target
+	PlanRename PlannedOpType = "rename"
+	// PlanShare 创建分享
+	PlanShare PlannedOpType = "share"
+	// PlanShareDelete 取消分享
+	PlanShareDelete PlannedOpType = "share_delete"
+	// PlanAbortUpload 取消未完成的分片上传并删除续传状态
+	PlanAbortUpload PlannedOpType = "abort_upload"
+	// PlanDeleteLocal 删除本地文件或目录
+	PlanDeleteLocal PlannedOpType = "delete_local"
+)
+
+// PlannedOperation 演练模式下本应执行的修改操作
+type PlannedOperation struct {
+	Type   PlannedOpType `json:"type"`
+	Path   string        `json:"path,omitempty"`
+	Target string        `json:"target,omitempty"`
+	Fids   []string      `json:"fids,omitempty"`
+	Size   int64         `json:"size,omitempty"`
+}
+
+// dryRunFidPrefix 演练时计划创建的目录使用的假fid前缀，列出这类目录时直接返回空列表
+const dryRunFidPrefix = "dryrun:"
+
+type dryRunKey struct{}
+
+// dryRunState 一次演练调用的状态，随ctx传递，计划创建的目录只保存在这里，不写入共享的目录缓存
+type dryRunState struct {
+	record func(op PlannedOperation)
+	mu     sync.Mutex
+	// dirs 计划创建的目录，key为父目录fid
+	dirs map[string][]File
+}
+
+// plannedDirs 返回parent下计划创建的目录
+func (s *dryRunState) plannedDirs(parent string) []File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dirs[parent]
+}
+
+func (s *dryRunState) addDir(dir File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dirs[dir.PdirFid] = append(s.dirs[dir.PdirFid], dir)
+}
+
+// withDryRun 返回开启演练模式的ctx，record接收计划执行的操作
+func withDryRun(ctx context.Context, record func(op PlannedOperation)) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, &dryRunState{record: record, dirs: make(map[string][]File)})
+}
+
+// dryRunCtx 演练模式下确保ctx带有本次调用的状态，WithDryRun 开启的演练每次调用使用独立的状态
+func (c *QuarkClient) dryRunCtx(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(dryRunKey{}).(*dryRunState); ok || !c.opts.dryRun {
+		return ctx
+	}
+	return withDryRun(ctx, c.opts.dryRunRecord)
+}
+
+// dryRun 判断是否处于演练模式，ctx中的设置优先于 WithDryRun
+func (c *QuarkClient) dryRun(ctx context.Context) (func(op PlannedOperation), bool) {
+	if state, ok := ctx.Value(dryRunKey{}).(*dryRunState); ok {
+		return state.record, true
+	}
+	return c.opts.dryRunRecord, c.opts.dryRun
+}
+
+// plan 演练模式下记录op并返回true，调用方不应再发送修改请求
+func (c *QuarkClient) plan(ctx context.Context, op PlannedOperation) bool {
+	record, ok := c.dryRun(ctx)
+	if !ok {
+		return false
+	}
+	c.logger.Info("dry run", "op", op.Type, "path", op.Path, "target", op.Target, "fids", op.Fids, "size", op.Size)
+	if record != nil {
+		record(op)
+	}
+	return true
+}
+
+func isDryRunFid(fid string) bool {
+	return strings.HasPrefix(fid, dryRunFidPrefix)
+}
@@ -0,0 +1,196 @@
+package quark
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/imroc/req/v3"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrNotFound     = errors.New("file not found")
+	ErrUnauthorized = errors.New("unauthorized or session expired")
+	// ErrAccessDenied OSS拒绝访问，如签名的下载链接过期或签名不匹配，与登录状态无关
+	ErrAccessDenied  = errors.New("access denied")
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrRateLimited   = errors.New("rate limited")
+	// ErrNameConflict 目标目录已存在同名文件，由 ConflictFail 的检查或HTTP 409产生，
+	// 夸克接口返回的错误码尚未确认，不会映射到此错误
+	ErrNameConflict = errors.New("name conflict")
+	// ErrFileBanned 文件已被封禁（File.Ban 为true）时下载返回，夸克接口的违规错误码尚未确认，不会映射到此错误
+	ErrFileBanned = errors.New("file banned")
+	// ErrRapidUploadMiss 只尝试秒传时，网盘中没有相同内容的文件
+	ErrRapidUploadMiss = errors.New("rapid upload miss")
+	// ErrVerifyFailed 删除本地文件前校验发现网盘文件与本地不一致
+	ErrVerifyFailed = errors.New("remote copy verification failed")
+	// ErrChecksumMismatch 下载的文件与网盘返回的大小或MD5不一致
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrFileChanged 下载接口返回的文件大小与传入的 File 不一致，需要重新获取文件信息
+	ErrFileChanged = errors.New("remote file changed")
+)
+
+// codeErrors 已确认的夸克错误码，未列出的错误码按HTTP状态码分类
+var codeErrors = map[int]error{
+	31001: ErrUnauthorized,  // require login
+	32003: ErrQuotaExceeded, // capacity limit
+}
+
+// ossCodeErrors OSS错误码对应的错误
+var ossCodeErrors = map[string]error{
+	"AccessDenied":          ErrAccessDenied,
+	"SignatureDoesNotMatch": ErrAccessDenied,
+	"InvalidAccessKeyId":    ErrAccessDenied,
+	"RequestTimeTooSkewed":  ErrAccessDenied,
+	"NoSuchKey":             ErrNotFound,
+	"NoSuchBucket":          ErrNotFound,
+	"NoSuchUpload":          ErrNotFound,
+	"SlowDown":              ErrRateLimited,
+}
+
+// statusErrors 夸克接口HTTP状态码对应的错误
+var statusErrors = map[int]error{
+	http.StatusUnauthorized:    ErrUnauthorized,
+	http.StatusForbidden:       ErrUnauthorized,
+	http.StatusNotFound:        ErrNotFound,
+	http.StatusConflict:        ErrNameConflict,
+	http.StatusTooManyRequests: ErrRateLimited,
+}
+
+// APIError 夸克接口或OSS返回的错误，可通过 errors.Is 判断错误类型
+type APIError struct {
+	// Status HTTP状态码，响应体中带有status时以其为准
+	Status int
+	// Code 夸克错误码，OSS错误时为0
+	Code int
+	// OSSCode OSS返回的错误码，如NoSuchUpload，夸克接口的错误时为空
+	OSSCode  string
+	Msg      string
+	ReqId    string
+	Endpoint string
+	// RetryAfter 服务端通过Retry-After要求的等待时间
+	RetryAfter time.Duration
+	kind       error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s status: %d, code: %d, msg: %s", e.Endpoint, e.Status, e.Code, e.Msg)
+}
+
+// Unwrap 返回对应的哨兵错误，未识别时为nil
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+func (e *APIError) classify() {
+	if kind, ok := codeErrors[e.Code]; ok {
+		e.kind = kind
+		return
+	}
+	e.kind = statusErrors[e.Status]
+}
+
+// newAPIError 根据夸克接口的响应构造错误
+func newAPIError(response *req.Response, code int, msg string) error {
+	e := &APIError{
+		Status:     response.StatusCode,
+		Code:       code,
+		Msg:        msg,
+		Endpoint:   response.Request.RawURL,
+		RetryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+	}
+	var body struct {
+		Status   int `json:"status"`
+		Metadata struct {
+			ReqId string `json:"req_id"`
+		} `json:"metadata"`
+	}
+	if json.Unmarshal(response.Bytes(), &body) == nil {
+		if body.Status != 0 {
+			e.Status = body.Status
+		}
+		e.ReqId = body.Metadata.ReqId
+	}
+	e.classify()
+	return e
+}
+
+// newOSSError 根据OSS的响应构造错误
+func newOSSError(response *req.Response) error {
+	e := &APIError{
+		Status:     response.StatusCode,
+		Msg:        response.String(),
+		Endpoint:   response.Request.RawURL,
+		RetryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+	}
+	var body struct {
+		Code      string `xml:"Code"`
+		Message   string `xml:"Message"`
+		RequestId string `xml:"RequestId"`
+	}
+	if xml.Unmarshal(response.Bytes(), &body) == nil {
+		e.Msg = body.Code + ": " + body.Message
+		e.OSSCode = body.Code
+		e.ReqId = body.RequestId
+	}
+	// OSS的401/403与夸克的登录状态无关，不能归为 ErrUnauthorized
+	if kind, ok := ossCodeErrors[body.Code]; ok {
+		e.kind = kind
+	} else if e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden {
+		e.kind = ErrAccessDenied
+	} else {
+		e.classify()
+	}
+	return e
+}
+
+// parseRetryAfter 解析Retry-After，支持秒数和HTTP时间两种格式
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// FileError 单个文件的错误
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// UploadPathError UploadPath 中上传失败的文件汇总，可通过 errors.Is 判断其中是否包含某类错误
+type UploadPathError struct {
+	Files []*FileError
+}
+
+func (e *UploadPathError) Error() string {
+	if len(e.Files) == 1 {
+		return "upload failed: " + e.Files[0].Error()
+	}
+	return fmt.Sprintf("%d files failed to upload, first: %s", len(e.Files), e.Files[0].Error())
+}
+
+func (e *UploadPathError) Unwrap() []error {
+	errs := make([]error, len(e.Files))
+	for i, file := range e.Files {
+		errs[i] = file
+	}
+	return errs
+}
@@ -0,0 +1,128 @@
+package quark
+
+import (
+	"bytes"
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileStoreBucket 状态所在的bucket
+var fileStoreBucket = []byte("state")
+
+// fileStoreLockTimeout 等待其他进程释放文件锁的时间
+var fileStoreLockTimeout = 5 * time.Second
+
+// fileStore 基于bbolt的单文件 StateStore，每次修改只写入对应的页。
+// 打开期间持有文件锁，其他进程打开同一文件时等待 fileStoreLockTimeout 后返回错误
+type fileStore struct {
+	db *bolt.DB
+}
+
+// NewFileStore 创建保存在单个文件中的 StateStore，文件不存在时会自动创建，
+// 已过期的key在打开时清理。返回的 StateStore 实现了 io.Closer，不再使用时应关闭以释放文件锁
+func NewFileStore(path string) (StateStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: fileStoreLockTimeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(fileStoreBucket)
+		if err != nil {
+			return err
+		}
+		expired := make([][]byte, 0)
+		err = bucket.ForEach(func(key, raw []byte) error {
+			if entry, err := decodeStateEntry(raw); err != nil || entry.expired() {
+				expired = append(expired, append([]byte(nil), key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err = bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &fileStore{db: db}, nil
+}
+
+func decodeStateEntry(raw []byte) (stateEntry, error) {
+	var entry stateEntry
+	err := json.Unmarshal(raw, &entry)
+	return entry, err
+}
+
+func (s *fileStore) Get(key string) ([]byte, bool, error) {
+	var (
+		value []byte
+		ok    bool
+	)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(fileStoreBucket).Get([]byte(key))
+		if raw == nil {
+			return nil
+		}
+		entry, err := decodeStateEntry(raw)
+		if err != nil {
+			return err
+		}
+		if !entry.expired() {
+			value, ok = entry.Value, true
+		}
+		return nil
+	})
+	return value, ok, err
+}
+
+func (s *fileStore) Set(key string, value []byte, ttl time.Duration) error {
+	raw, err := json.Marshal(newStateEntry(value, ttl))
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(fileStoreBucket).Put([]byte(key), raw)
+	})
+}
+
+func (s *fileStore) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(fileStoreBucket).Delete([]byte(key))
+	})
+}
+
+func (s *fileStore) List(prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(fileStoreBucket).Cursor()
+		for key, raw := cursor.Seek([]byte(prefix)); key != nil && bytes.HasPrefix(key, []byte(prefix)); key, raw = cursor.Next() {
+			entry, err := decodeStateEntry(raw)
+			if err != nil {
+				return err
+			}
+			if !entry.expired() {
+				keys = append(keys, string(key))
+			}
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// Close 关闭文件并释放文件锁
+func (s *fileStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,14 @@
+package quark
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func fileCreatedAt(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Birthtimespec.Unix())
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !windows
+
+package quark
+
+import (
+	"os"
+	"time"
+)
+
+// fileCreatedAt 标准库在其他系统上拿不到文件的创建时间，使用修改时间代替
+func fileCreatedAt(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
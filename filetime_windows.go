@@ -0,0 +1,14 @@
+package quark
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func fileCreatedAt(info os.FileInfo) time.Time {
+	if data, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, data.CreationTime.Nanoseconds())
+	}
+	return info.ModTime()
+}
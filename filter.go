@@ -0,0 +1,298 @@
+package quark
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultIgnoreFile 遍历目录时读取的忽略规则文件名
+const defaultIgnoreFile = ".quarkignore"
+
+// fileFilter UploadPath 的过滤规则，判断顺序见 OneStepUploadPathReq
+type fileFilter struct {
+	ignorePaths      []string
+	ignoreFiles      []string
+	extensions       []string
+	ignoreExtensions []string
+	include          []string
+	exclude          []string
+	includeRe        []*regexp.Regexp
+	excludeRe        []*regexp.Regexp
+	minSize          int64
+	maxSize          int64
+	modifiedAfter    time.Time
+	modifiedBefore   time.Time
+	// ignoreFile 为空时不读取忽略规则文件
+	ignoreFile string
+	// ignoreRules 各目录下忽略规则文件中的规则，key为相对于根目录的路径，根目录为空字符串
+	ignoreRules map[string][]ignoreRule
+}
+
+func newFileFilter(req OneStepUploadPathReq) (*fileFilter, error) {
+	f := &fileFilter{
+		ignorePaths:      req.IgnorePaths,
+		ignoreFiles:      req.IgnoreFiles,
+		extensions:       req.Extensions,
+		ignoreExtensions: req.IgnoreExtensions,
+		include:          req.IncludeGlobs,
+		exclude:          req.ExcludeGlobs,
+		minSize:          req.MinSize,
+		maxSize:          req.MaxSize,
+		modifiedAfter:    req.ModifiedAfter,
+		modifiedBefore:   req.ModifiedBefore,
+		ignoreRules:      make(map[string][]ignoreRule),
+	}
+	if !req.NoIgnoreFile {
+		f.ignoreFile = defaultIgnoreFile
+	}
+	for _, pattern := range append(f.include, f.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", pattern, err)
+		}
+	}
+	var err error
+	if f.includeRe, err = compileRegexps(req.IncludeRegexps); err != nil {
+		return nil, err
+	}
+	if f.excludeRe, err = compileRegexps(req.ExcludeRegexps); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func compileRegexps(exprs []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp %q: %w", expr, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// loadIgnoreFile 读取目录dir下的忽略规则文件，rel为dir相对于根目录的路径
+func (f *fileFilter) loadIgnoreFile(dir, rel string) error {
+	if f.ignoreFile == "" {
+		return nil
+	}
+	file, err := os.Open(filepath.Join(dir, f.ignoreFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	rules := make([]ignoreRule, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	f.ignoreRules[rel] = rules
+	return nil
+}
+
+// skipDir 判断是否跳过整个目录，rel为相对于根目录、以/分隔的路径，返回跳过的原因
+func (f *fileFilter) skipDir(rel string, info os.FileInfo) (bool, string) {
+	for _, ignorePath := range f.ignorePaths {
+		if info.Name() == ignorePath {
+			return true, "ignore path " + ignorePath
+		}
+	}
+	if f.ignoredByFile(rel, true) {
+		return true, f.ignoreFile
+	}
+	if pattern, ok := matchAnyGlob(f.exclude, rel); ok {
+		return true, "exclude " + pattern
+	}
+	if re, ok := matchAnyRegexp(f.excludeRe, rel); ok {
+		return true, "exclude " + re
+	}
+	return false, ""
+}
+
+// skipFile 判断是否跳过文件，rel为相对于根目录、以/分隔的路径，返回跳过的原因
+func (f *fileFilter) skipFile(rel string, info os.FileInfo) (bool, string) {
+	name := info.Name()
+	if f.ignoredByFile(rel, false) {
+		return true, f.ignoreFile
+	}
+	if pattern, ok := matchAnyGlob(f.exclude, rel); ok {
+		return true, "exclude " + pattern
+	}
+	if re, ok := matchAnyRegexp(f.excludeRe, rel); ok {
+		return true, "exclude " + re
+	}
+	for _, ignoreFile := range f.ignoreFiles {
+		if name == ignoreFile {
+			return true, "ignore file " + ignoreFile
+		}
+	}
+	for _, extension := range f.ignoreExtensions {
+		if strings.HasSuffix(name, extension) {
+			return true, "ignore extension " + extension
+		}
+	}
+	if len(f.include) > 0 || len(f.includeRe) > 0 {
+		_, globOk := matchAnyGlob(f.include, rel)
+		_, reOk := matchAnyRegexp(f.includeRe, rel)
+		if !globOk && !reOk {
+			return true, "not included"
+		}
+	}
+	if len(f.extensions) > 0 {
+		matched := false
+		for _, extension := range f.extensions {
+			if strings.HasSuffix(name, extension) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return true, "extension not included"
+		}
+	}
+	if f.minSize > 0 && info.Size() < f.minSize {
+		return true, fmt.Sprintf("size %d < %d", info.Size(), f.minSize)
+	}
+	if f.maxSize > 0 && info.Size() > f.maxSize {
+		return true, fmt.Sprintf("size %d > %d", info.Size(), f.maxSize)
+	}
+	if !f.modifiedAfter.IsZero() && !info.ModTime().After(f.modifiedAfter) {
+		return true, "modified before " + f.modifiedAfter.String()
+	}
+	if !f.modifiedBefore.IsZero() && !info.ModTime().Before(f.modifiedBefore) {
+		return true, "modified after " + f.modifiedBefore.String()
+	}
+	return false, ""
+}
+
+// ignoredByFile 按gitignore的语义判断rel是否被忽略：从根目录到上级目录依次应用各忽略规则文件，
+// 每条规则匹配相对于所在目录的路径，最后一条匹配的规则生效，!开头的规则重新包含
+func (f *fileFilter) ignoredByFile(rel string, isDir bool) bool {
+	if len(f.ignoreRules) == 0 {
+		return false
+	}
+	ignored := false
+	parts := strings.Split(rel, "/")
+	for i := 0; i < len(parts); i++ {
+		rules := f.ignoreRules[strings.Join(parts[:i], "/")]
+		sub := strings.Join(parts[i:], "/")
+		for _, rule := range rules {
+			if rule.match(sub, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// ignoreRule 忽略规则文件中的一行
+type ignoreRule struct {
+	pattern string
+	negate  bool
+	// dirOnly 以/结尾，只匹配目录
+	dirOnly bool
+	// anchored 包含/，只匹配相对于规则文件所在目录的路径，否则匹配任意层级
+	anchored bool
+}
+
+// parseIgnoreRule 解析一行gitignore格式的规则，空行和#开头的注释返回false
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	var rule ignoreRule
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+	rule.pattern = line
+	return rule, true
+}
+
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.anchored {
+		return matchGlob(r.pattern, rel)
+	}
+	return matchGlob("**/"+r.pattern, rel)
+}
+
+// matchGlob 匹配以/分隔的路径，支持 path.Match 的语法，** 匹配零或多级目录，
+// 位于末尾时匹配其下的所有内容
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			if len(rest) == 0 {
+				return len(name) > 0
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func matchAnyGlob(patterns []string, rel string) (string, bool) {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, rel) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+func matchAnyRegexp(res []*regexp.Regexp, rel string) (string, bool) {
+	for _, re := range res {
+		if re.MatchString(rel) {
+			return re.String(), true
+		}
+	}
+	return "", false
+}
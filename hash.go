@@ -0,0 +1,62 @@
+package quark
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// hashCacheTTL 哈希缓存的有效期，文件不再上传时缓存自动过期
+var hashCacheTTL = 30 * 24 * time.Hour
+
+// FileHash 文件的MD5和SHA1
+type FileHash struct {
+	Md5  string `json:"md5"`
+	Sha1 string `json:"sha1"`
+}
+
+// hashCacheEntry 缓存的哈希及计算时文件的大小、修改时间和inode，任一变化时缓存失效
+type hashCacheEntry struct {
+	Hash    FileHash `json:"hash"`
+	Size    int64    `json:"size"`
+	ModTime int64    `json:"mod_time"`
+	Inode   uint64   `json:"inode"`
+}
+
+func newHashCacheEntry(hash FileHash, info os.FileInfo) hashCacheEntry {
+	return hashCacheEntry{Hash: hash, Size: info.Size(), ModTime: info.ModTime().UnixNano(), Inode: fileInode(info)}
+}
+
+// hashCacheKey 以路径作为缓存key，同一文件修改后覆盖原有的缓存
+func hashCacheKey(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	return "hash_" + md5Hash(abs)
+}
+
+// fileHash 计算文件的MD5和SHA1，useCache时优先使用缓存的结果
+func (c *QuarkClient) fileHash(ctx context.Context, path string, info os.FileInfo, useCache bool) (FileHash, error) {
+	key := hashCacheKey(path)
+	if useCache {
+		var entry hashCacheEntry
+		if ok, err := c.getState(key, &entry); ok && err == nil && entry.Hash.Md5 != "" && entry.Hash.Sha1 != "" &&
+			entry == newHashCacheEntry(entry.Hash, info) {
+			c.logger.Debug("hash cache hit", "path", path)
+			return entry.Hash, nil
+		}
+	}
+	md5Str, sha1Str, err := getFileHash(ctx, path)
+	if err != nil {
+		return FileHash{}, err
+	}
+	hash := FileHash{Md5: md5Str, Sha1: sha1Str}
+	if useCache {
+		if err = c.setState(key, newHashCacheEntry(hash, info), hashCacheTTL); err != nil {
+			c.logger.Warn("cache error", "key", key, "path", path, "err", err)
+		}
+	}
+	return hash, nil
+}
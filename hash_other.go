@@ -0,0 +1,10 @@
+//go:build !unix
+
+package quark
+
+import "os"
+
+// fileInode 非unix系统没有inode，只依赖路径、大小和修改时间
+func fileInode(os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,114 @@
+package quark
+
+import (
+	"context"
+	"github.com/imroc/req/v3"
+	"sync"
+	"time"
+)
+
+// EndpointClass 接口分类，不同分类可以设置不同的请求频率
+type EndpointClass int
+
+const (
+	// ClassList 列表、查询类接口
+	ClassList EndpointClass = iota
+	// ClassMutation 创建、移动、删除、分享等修改类接口
+	ClassMutation
+	// ClassUploadAuth 上传分片签名接口
+	ClassUploadAuth
+	// ClassDownloadLink 获取下载地址接口
+	ClassDownloadLink
+)
+
+var endpointClasses = map[string]EndpointClass{
+	"/config":              ClassList,
+	"/file/sort":           ClassList,
+	"/task":                ClassList,
+	"/share/mypage/detail": ClassList,
+	"/share/password":      ClassList,
+	"/file":                ClassMutation,
+	"/file/move":           ClassMutation,
+	"/file/rename":         ClassMutation,
+	"/file/delete":         ClassMutation,
+	"/file/upload/pre":     ClassMutation,
+	"/file/update/hash":    ClassMutation,
+	"/file/upload/finish":  ClassMutation,
+	"/share":               ClassMutation,
+	"/share/delete":        ClassMutation,
+	"/file/upload/auth":    ClassUploadAuth,
+	"/file/download":       ClassDownloadLink,
+}
+
+type rateLimit struct {
+	rate  float64
+	burst int
+}
+
+// tokenBucket 令牌桶，rate为每秒生成的令牌数，burst为桶容量
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve 取走n个令牌，返回需要等待的时间，令牌允许透支
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// wait 阻塞直到拿到一个令牌或ctx取消
+func (b *tokenBucket) wait(ctx context.Context) error {
+	return sleepCtx(ctx, b.reserve(1))
+}
+
+// requestLimiter 按接口分类限制请求频率，可在多个goroutine间共享
+type requestLimiter struct {
+	buckets map[EndpointClass]*tokenBucket
+}
+
+func newRequestLimiter(limits map[EndpointClass]rateLimit) *requestLimiter {
+	l := &requestLimiter{buckets: make(map[EndpointClass]*tokenBucket)}
+	for class, limit := range limits {
+		if limit.rate > 0 {
+			l.buckets[class] = newTokenBucket(limit.rate, limit.burst)
+		}
+	}
+	return l
+}
+
+// onBeforeRequest 作为sessionClient的中间件，请求发出前等待令牌
+func (l *requestLimiter) onBeforeRequest(_ *req.Client, r *req.Request) error {
+	class, ok := endpointClasses[r.RawURL]
+	if !ok {
+		return nil
+	}
+	bucket, ok := l.buckets[class]
+	if !ok {
+		return nil
+	}
+	return bucket.wait(r.Context())
+}
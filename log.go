@@ -0,0 +1,14 @@
+package quark
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler 丢弃所有日志，作为默认的logger
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
@@ -1,24 +1,28 @@
 package quark
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 )
 
-func checkTaskSuccess(finish bool, successResult RespDataWithMeta[TaskDoing, TaskMeta], c *QuarkClient) error {
+func checkTaskSuccess(ctx context.Context, finish bool, successResult RespDataWithMeta[TaskDoing, TaskMeta], c *QuarkClient) error {
 	isFinish := finish
 	for {
 		if isFinish {
 			break
 		}
-		time.Sleep(time.Duration(successResult.Metadata.TqGap) * time.Millisecond)
-		query, err := c.TaskQuery(successResult.Data.TaskId)
+		if err := sleepCtx(ctx, time.Duration(successResult.Metadata.TqGap)*time.Millisecond); err != nil {
+			return err
+		}
+		query, err := c.TaskQueryCtx(ctx, successResult.Data.TaskId)
 		if err != nil {
 			return err
 		}
@@ -28,36 +32,56 @@ func checkTaskSuccess(finish bool, successResult RespDataWithMeta[TaskDoing, Tas
 	return nil
 }
 
-func (c *QuarkClient) Config() (*RespData[Config], error) {
-	r := c.sessionClient.R()
-	var successResult RespData[Config]
-	var errorResult Resp
-	r.SetSuccessResult(&successResult)
-	r.SetErrorResult(&errorResult)
-	response, err := r.Get("/config")
-	if err != nil {
-		return nil, err
-	}
-	if response.IsErrorState() {
-		return nil, fmt.Errorf("code: %d, msg: %s", errorResult.Code, errorResult.Msg)
+// ossUrl 拼接分片上传的OSS地址，配置了 WithOSSHost 时直接使用该地址
+func (c *QuarkClient) ossUrl(bucket, uploadUrl, objKey string) string {
+	if c.opts.ossHost != "" {
+		return strings.TrimRight(c.opts.ossHost, "/") + "/" + objKey
 	}
-	if successResult.Status >= 400 || successResult.Code != 0 {
-		return nil, fmt.Errorf("code: %d, msg: %s", successResult.Code, successResult.Msg)
-	}
-	for _, cookie := range response.Cookies() {
-		if cookie.Name == "__pus" {
-			c.refreshPus(cookie.Value)
+	return fmt.Sprintf("https://%s.%s/%s", bucket, strings.TrimPrefix(uploadUrl, "http://"), objKey)
+}
+
+func (c *QuarkClient) Config() (*RespData[Config], error) {
+	return c.ConfigCtx(context.Background())
+}
+
+func (c *QuarkClient) ConfigCtx(ctx context.Context) (*RespData[Config], error) {
+	return retry(ctx, c.opts.retry, func() (*RespData[Config], error) {
+		r := c.sessionClient.R().SetContext(ctx)
+		var successResult RespData[Config]
+		var errorResult Resp
+		r.SetSuccessResult(&successResult)
+		r.SetErrorResult(&errorResult)
+		response, err := r.Get("/config")
+		if err != nil {
+			return nil, err
 		}
-		if cookie.Name == "__puus" {
-			c.refreshPuus(cookie.Value)
+		if response.IsErrorState() {
+			return nil, newAPIError(response, errorResult.Code, errorResult.Msg)
 		}
-	}
-	return &successResult, nil
+		if successResult.Status >= 400 || successResult.Code != 0 {
+			return nil, newAPIError(response, successResult.Code, successResult.Msg)
+		}
+		for _, cookie := range response.Cookies() {
+			if cookie.Name == "__pus" {
+				c.refreshPus(cookie.Value)
+			}
+			if cookie.Name == "__puus" {
+				c.refreshPuus(cookie.Value)
+			}
+		}
+		return &successResult, nil
+	})
 }
 
 func (c *QuarkClient) FileSort(parent string) ([]File, error) {
+	return c.FileSortCtx(context.Background(), parent)
+}
+
+func (c *QuarkClient) FileSortCtx(ctx context.Context, parent string) ([]File, error) {
 	files := make([]File, 0)
-	r := c.sessionClient.R()
+	if isDryRunFid(parent) {
+		return files, nil
+	}
 	page := 1
 	size := 100
 	query := map[string]string{
@@ -65,23 +89,30 @@ func (c *QuarkClient) FileSort(parent string) ([]File, error) {
 		"_size":        strconv.Itoa(size),
 		"_fetch_total": "1",
 	}
-	var successResult RespDataWithMeta[FileList, SortMeta]
-	var errorResult Resp
-	r.SetSuccessResult(&successResult)
-	r.SetErrorResult(&errorResult)
 	for {
 		query["_page"] = strconv.Itoa(page)
-		r.SetQueryParams(query)
-		response, err := r.Get("/file/sort")
+		successResult, err := retry(ctx, c.opts.retry, func() (*RespDataWithMeta[FileList, SortMeta], error) {
+			r := c.sessionClient.R().SetContext(ctx)
+			var successResult RespDataWithMeta[FileList, SortMeta]
+			var errorResult Resp
+			r.SetSuccessResult(&successResult)
+			r.SetErrorResult(&errorResult)
+			r.SetQueryParams(query)
+			response, err := r.Get("/file/sort")
+			if err != nil {
+				return nil, err
+			}
+			if response.IsErrorState() {
+				return nil, newAPIError(response, errorResult.Code, errorResult.Msg)
+			}
+			if successResult.Status >= 400 || successResult.Code != 0 {
+				return nil, newAPIError(response, successResult.Code, successResult.Msg)
+			}
+			return &successResult, nil
+		})
 		if err != nil {
 			return nil, err
 		}
-		if response.IsErrorState() {
-			return nil, fmt.Errorf("code: %d, msg: %s", errorResult.Code, errorResult.Msg)
-		}
-		if successResult.Status >= 400 || successResult.Code != 0 {
-			return nil, fmt.Errorf("code: %d, msg: %s", successResult.Code, successResult.Msg)
-		}
 		files = append(files, successResult.Data.List...)
 		if page*size >= successResult.Metadata.Total {
 			break
@@ -93,7 +124,14 @@ func (c *QuarkClient) FileSort(parent string) ([]File, error) {
 }
 
 func (c *QuarkClient) MakeDir(dirName, dstId string) (*RespData[Dir], error) {
-	r := c.sessionClient.R()
+	return c.MakeDirCtx(context.Background(), dirName, dstId)
+}
+
+func (c *QuarkClient) MakeDirCtx(ctx context.Context, dirName, dstId string) (*RespData[Dir], error) {
+	if c.plan(ctx, PlannedOperation{Type: PlanMakeDir, Path: dirName, Fids: []string{dstId}}) {
+		return &RespData[Dir]{Data: Dir{Finish: true, Fid: dryRunFidPrefix + dstId + "/" + dirName}}, nil
+	}
+	r := c.sessionClient.R().SetContext(ctx)
 	var successResult RespData[Dir]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -110,16 +148,23 @@ func (c *QuarkClient) MakeDir(dirName, dstId string) (*RespData[Dir], error) {
 		return nil, err
 	}
 	if response.IsErrorState() {
-		return nil, fmt.Errorf("code: %d, msg: %s", errorResult.Code, errorResult.Msg)
+		return nil, newAPIError(response, errorResult.Code, errorResult.Msg)
 	}
 	if successResult.Status >= 400 || successResult.Code != 0 {
-		return nil, fmt.Errorf("code: %d, msg: %s", successResult.Code, successResult.Msg)
+		return nil, newAPIError(response, successResult.Code, successResult.Msg)
 	}
 	return &successResult, nil
 }
 
 func (c *QuarkClient) FileMove(objIds []string, dstId string) error {
-	r := c.sessionClient.R()
+	return c.FileMoveCtx(context.Background(), objIds, dstId)
+}
+
+func (c *QuarkClient) FileMoveCtx(ctx context.Context, objIds []string, dstId string) error {
+	if c.plan(ctx, PlannedOperation{Type: PlanMove, Fids: objIds, Target: dstId}) {
+		return nil
+	}
+	r := c.sessionClient.R().SetContext(ctx)
 	var successResult RespDataWithMeta[TaskDoing, TaskMeta]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -136,17 +181,24 @@ func (c *QuarkClient) FileMove(objIds []string, dstId string) error {
 		return err
 	}
 	if response.IsErrorState() {
-		return fmt.Errorf("code: %d, msg: %s", errorResult.Code, errorResult.Msg)
+		return newAPIError(response, errorResult.Code, errorResult.Msg)
 	}
 	if successResult.Status >= 400 || successResult.Code != 0 {
-		return fmt.Errorf("code: %d, msg: %s", successResult.Code, successResult.Msg)
+		return newAPIError(response, successResult.Code, successResult.Msg)
 	}
 	finish := successResult.Data.Finish
-	return checkTaskSuccess(finish, successResult, c)
+	return checkTaskSuccess(ctx, finish, successResult, c)
 }
 
 func (c *QuarkClient) FileRename(objId, newName string) error {
-	r := c.sessionClient.R()
+	return c.FileRenameCtx(context.Background(), objId, newName)
+}
+
+func (c *QuarkClient) FileRenameCtx(ctx context.Context, objId, newName string) error {
+	if c.plan(ctx, PlannedOperation{Type: PlanRename, Fids: []string{objId}, Target: newName}) {
+		return nil
+	}
+	r := c.sessionClient.R().SetContext(ctx)
 	var successResult RespDataWithMeta[TaskDoing, TaskMeta]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -161,17 +213,24 @@ func (c *QuarkClient) FileRename(objId, newName string) error {
 		return err
 	}
 	if response.IsErrorState() {
-		return fmt.Errorf("code: %d, msg: %s", errorResult.Code, errorResult.Msg)
+		return newAPIError(response, errorResult.Code, errorResult.Msg)
 	}
 	if successResult.Status >= 400 || successResult.Code != 0 {
-		return fmt.Errorf("code: %d, msg: %s", successResult.Code, successResult.Msg)
+		return newAPIError(response, successResult.Code, successResult.Msg)
 	}
 	finish := successResult.Data.Finish
-	return checkTaskSuccess(finish, successResult, c)
+	return checkTaskSuccess(ctx, finish, successResult, c)
 }
 
 func (c *QuarkClient) FileDelete(objIds []string) error {
-	r := c.sessionClient.R()
+	return c.FileDeleteCtx(context.Background(), objIds)
+}
+
+func (c *QuarkClient) FileDeleteCtx(ctx context.Context, objIds []string) error {
+	if c.plan(ctx, PlannedOperation{Type: PlanDelete, Fids: objIds}) {
+		return nil
+	}
+	r := c.sessionClient.R().SetContext(ctx)
 	var successResult RespDataWithMeta[TaskDoing, TaskMeta]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -187,52 +246,69 @@ func (c *QuarkClient) FileDelete(objIds []string) error {
 		return err
 	}
 	if response.IsErrorState() {
-		return fmt.Errorf("code: %d, msg: %s", errorResult.Code, errorResult.Msg)
+		return newAPIError(response, errorResult.Code, errorResult.Msg)
 	}
 	if successResult.Status >= 400 || successResult.Code != 0 {
-		return fmt.Errorf("code: %d, msg: %s", successResult.Code, successResult.Msg)
+		return newAPIError(response, successResult.Code, successResult.Msg)
 	}
 	finish := successResult.Data.Finish
-	return checkTaskSuccess(finish, successResult, c)
+	return checkTaskSuccess(ctx, finish, successResult, c)
 }
 
 func (c *QuarkClient) TaskQuery(taskId string) (*RespDataWithMeta[Task, TaskMeta], error) {
-	r := c.sessionClient.R()
-	var successResult RespDataWithMeta[Task, TaskMeta]
-	var errorResult Resp
-	r.SetSuccessResult(&successResult)
-	r.SetErrorResult(&errorResult)
-	r.SetQueryParamsAnyType(map[string]any{
-		"task_id": taskId,
+	return c.TaskQueryCtx(context.Background(), taskId)
+}
+
+func (c *QuarkClient) TaskQueryCtx(ctx context.Context, taskId string) (*RespDataWithMeta[Task, TaskMeta], error) {
+	return retry(ctx, c.opts.retry, func() (*RespDataWithMeta[Task, TaskMeta], error) {
+		r := c.sessionClient.R().SetContext(ctx)
+		var successResult RespDataWithMeta[Task, TaskMeta]
+		var errorResult Resp
+		r.SetSuccessResult(&successResult)
+		r.SetErrorResult(&errorResult)
+		r.SetQueryParamsAnyType(map[string]any{
+			"task_id": taskId,
+		})
+		// task
+		response, err := r.Get("/task")
+		if err != nil {
+			return nil, err
+		}
+		if response.IsErrorState() {
+			return nil, newAPIError(response, errorResult.Code, errorResult.Msg)
+		}
+		if successResult.Status >= 400 || successResult.Code != 0 {
+			return nil, newAPIError(response, successResult.Code, successResult.Msg)
+		}
+		return &successResult, nil
 	})
-	// task
-	response, err := r.Get("/task")
-	if err != nil {
-		return nil, err
-	}
-	if response.IsErrorState() {
-		return nil, fmt.Errorf("code: %d, msg: %s", errorResult.Code, errorResult.Msg)
-	}
-	if successResult.Status >= 400 || successResult.Code != 0 {
-		return nil, fmt.Errorf("code: %d, msg: %s", successResult.Code, successResult.Msg)
-	}
-	return &successResult, nil
 }
 
 func (c *QuarkClient) FileUploadPre(req FileUpPreReq) (*RespDataWithMeta[FileUpPre, FileUpPreMeta], error) {
-	r := c.sessionClient.R()
+	return c.FileUploadPreCtx(context.Background(), req)
+}
+
+func (c *QuarkClient) FileUploadPreCtx(ctx context.Context, req FileUpPreReq) (*RespDataWithMeta[FileUpPre, FileUpPreMeta], error) {
+	r := c.sessionClient.R().SetContext(ctx)
 	var successResult RespDataWithMeta[FileUpPre, FileUpPreMeta]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
 	r.SetErrorResult(&errorResult)
-	now := time.Now()
+	updatedAt := req.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = time.Now()
+	}
+	createdAt := req.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = updatedAt
+	}
 	response, err := r.SetBody(map[string]any{
 		"ccp_hash_update": true,
 		"dir_name":        "",
 		"file_name":       req.FileName,
 		"format_type":     req.MimeType,
-		"l_created_at":    now.UnixMilli(),
-		"l_updated_at":    now.UnixMilli(),
+		"l_created_at":    createdAt.UnixMilli(),
+		"l_updated_at":    updatedAt.UnixMilli(),
 		"pdir_fid":        req.ParentId,
 		"size":            req.FileSize,
 	}).Post("/file/upload/pre")
@@ -240,36 +316,70 @@ func (c *QuarkClient) FileUploadPre(req FileUpPreReq) (*RespDataWithMeta[FileUpP
 		return nil, err
 	}
 	if response.IsErrorState() {
-		return nil, fmt.Errorf("code: %d, msg: %s", errorResult.Code, errorResult.Msg)
+		return nil, newAPIError(response, errorResult.Code, errorResult.Msg)
 	}
 	if successResult.Status >= 400 || successResult.Code != 0 {
-		return nil, fmt.Errorf("code: %d, msg: %s", successResult.Code, successResult.Msg)
+		return nil, newAPIError(response, successResult.Code, successResult.Msg)
 	}
 
 	return &successResult, nil
 }
 
 func (c *QuarkClient) FileUploadHash(req FileUpHashReq) (*RespData[FileUpHash], error) {
-	r := c.sessionClient.R()
-	var successResult RespData[FileUpHash]
-	var errorResult Resp
-	r.SetSuccessResult(&successResult)
-	r.SetErrorResult(&errorResult)
-	response, err := r.SetBody(req).Post("/file/update/hash")
-	if err != nil {
-		return nil, err
-	}
-	if response.IsErrorState() {
-		return nil, fmt.Errorf("code: %d, msg: %s", errorResult.Code, errorResult.Msg)
-	}
-	if successResult.Status >= 400 || successResult.Code != 0 {
-		return nil, fmt.Errorf("code: %d, msg: %s", successResult.Code, successResult.Msg)
-	}
+	return c.FileUploadHashCtx(context.Background(), req)
+}
 
-	return &successResult, nil
+func (c *QuarkClient) FileUploadHashCtx(ctx context.Context, req FileUpHashReq) (*RespData[FileUpHash], error) {
+	return retry(ctx, c.opts.retry, func() (*RespData[FileUpHash], error) {
+		r := c.sessionClient.R().SetContext(ctx)
+		var successResult RespData[FileUpHash]
+		var errorResult Resp
+		r.SetSuccessResult(&successResult)
+		r.SetErrorResult(&errorResult)
+		response, err := r.SetBody(req).Post("/file/update/hash")
+		if err != nil {
+			return nil, err
+		}
+		if response.IsErrorState() {
+			return nil, newAPIError(response, errorResult.Code, errorResult.Msg)
+		}
+		if successResult.Status >= 400 || successResult.Code != 0 {
+			return nil, newAPIError(response, successResult.Code, successResult.Msg)
+		}
+
+		return &successResult, nil
+	})
 }
 
 func (c *QuarkClient) FileUpPart(req FileUpPartReq) (string, error) {
+	return c.FileUpPartCtx(context.Background(), req)
+}
+
+func (c *QuarkClient) FileUpPartCtx(ctx context.Context, req FileUpPartReq) (string, error) {
+	// 只有可回退的Reader才能重传分片
+	policy := c.opts.retry
+	seeker, ok := req.Reader.(io.Seeker)
+	var start int64
+	if ok {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seeker = nil
+		}
+	}
+	if seeker == nil {
+		policy.MaxAttempts = 1
+	}
+	return retry(ctx, policy, func() (string, error) {
+		if seeker != nil {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return "", err
+			}
+		}
+		return c.fileUpPart(ctx, req)
+	})
+}
+
+func (c *QuarkClient) fileUpPart(ctx context.Context, req FileUpPartReq) (string, error) {
 	timeStr := time.Now().UTC().Format(http.TimeFormat)
 	data := map[string]any{
 		"auth_info": req.AuthInfo,
@@ -282,17 +392,21 @@ x-oss-user-agent:aliyun-sdk-js/6.6.1 Chrome 98.0.4758.80 on Windows 10 64-bit
 /%s/%s?partNumber=%d&uploadId=%s`, req.MineType, timeStr, timeStr, req.Bucket, req.ObjKey, req.PartNumber, req.UploadId),
 		"task_id": req.TaskId,
 	}
-	r := c.sessionClient.R()
+	r := c.sessionClient.R().SetContext(ctx)
 	var resp RespData[FileUpAuth]
 	r.SetSuccessResult(&resp)
+	r.SetErrorResult(&resp)
 	r.SetBody(data)
-	_, err := r.Post("/file/upload/auth")
+	response, err := r.Post("/file/upload/auth")
 	if err != nil {
 		return "", err
 	}
+	if response.IsErrorState() || resp.Code != 0 {
+		return "", newAPIError(response, resp.Code, resp.Msg)
+	}
 
-	u := fmt.Sprintf("https://%s.%s/%s", req.Bucket, req.UploadUrl[7:], req.ObjKey)
-	r = c.defaultClient.R()
+	u := c.ossUrl(req.Bucket, req.UploadUrl, req.ObjKey)
+	r = c.defaultClient.R().SetContext(ctx)
 	r.SetHeaders(map[string]string{
 		"Authorization":    resp.Data.AuthKey,
 		"Content-Type":     req.MineType,
@@ -303,19 +417,28 @@ x-oss-user-agent:aliyun-sdk-js/6.6.1 Chrome 98.0.4758.80 on Windows 10 64-bit
 		SetQueryParams(map[string]string{
 			"partNumber": strconv.Itoa(req.PartNumber),
 			"uploadId":   req.UploadId,
-		}).SetBody(req.Reader)
+		}).SetBody(c.bandwidth.reader(ctx, req.Reader))
 
 	res, err := r.Put(u)
 	if err != nil {
 		return "", err
 	}
 	if res.StatusCode != 200 {
-		return "", fmt.Errorf("up status: %d, error: %s", res.StatusCode, res.String())
+		return "", newOSSError(res)
 	}
 	return res.Header.Get("ETag"), nil
 }
 
 func (c *QuarkClient) FileUpCommit(req FileUpCommitReq, md5s []string) error {
+	return c.FileUpCommitCtx(context.Background(), req, md5s)
+}
+
+// FileUpCommitCtx 合并分片，合并会完成上传且不是幂等操作，失败时不重试
+func (c *QuarkClient) FileUpCommitCtx(ctx context.Context, req FileUpCommitReq, md5s []string) error {
+	return c.fileUpCommit(ctx, req, md5s)
+}
+
+func (c *QuarkClient) fileUpCommit(ctx context.Context, req FileUpCommitReq, md5s []string) error {
 	timeStr := time.Now().UTC().Format(http.TimeFormat)
 	bodyBuilder := strings.Builder{}
 	bodyBuilder.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
@@ -353,16 +476,20 @@ x-oss-user-agent:aliyun-sdk-js/6.6.1 Chrome 98.0.4758.80 on Windows 10 64-bit
 		"task_id": req.TaskId,
 	}
 	var resp RespData[FileUpAuth]
-	r := c.sessionClient.R()
+	r := c.sessionClient.R().SetContext(ctx)
 	r.SetSuccessResult(&resp)
+	r.SetErrorResult(&resp)
 	r.SetBody(data)
-	_, err = r.Post("/file/upload/auth")
+	response, err := r.Post("/file/upload/auth")
 	if err != nil {
 		return err
 	}
+	if response.IsErrorState() || resp.Code != 0 {
+		return newAPIError(response, resp.Code, resp.Msg)
+	}
 
-	r = c.defaultClient.R()
-	u := fmt.Sprintf("https://%s.%s/%s", req.Bucket, req.UploadUrl[7:], req.ObjKey)
+	r = c.defaultClient.R().SetContext(ctx)
+	u := c.ossUrl(req.Bucket, req.UploadUrl, req.ObjKey)
 	res, err := r.
 		SetHeaders(map[string]string{
 			"Authorization":    resp.Data.AuthKey,
@@ -380,13 +507,76 @@ x-oss-user-agent:aliyun-sdk-js/6.6.1 Chrome 98.0.4758.80 on Windows 10 64-bit
 		return err
 	}
 	if res.StatusCode != 200 {
-		return fmt.Errorf("up status: %d, error: %s", res.StatusCode, res.String())
+		return newOSSError(res)
+	}
+	return nil
+}
+
+func (c *QuarkClient) FileUpAbort(req FileUpAbortReq) error {
+	return c.FileUpAbortCtx(context.Background(), req)
+}
+
+// FileUpAbortCtx 取消OSS的分片上传，会话已不存在时不返回错误
+func (c *QuarkClient) FileUpAbortCtx(ctx context.Context, req FileUpAbortReq) error {
+	return retryErr(ctx, c.opts.retry, func() error {
+		return c.fileUpAbort(ctx, req)
+	})
+}
+
+func (c *QuarkClient) fileUpAbort(ctx context.Context, req FileUpAbortReq) error {
+	timeStr := time.Now().UTC().Format(http.TimeFormat)
+	data := map[string]any{
+		"auth_info": req.AuthInfo,
+		"auth_meta": fmt.Sprintf(`DELETE
+
+
+%s
+x-oss-date:%s
+x-oss-user-agent:aliyun-sdk-js/6.6.1 Chrome 98.0.4758.80 on Windows 10 64-bit
+/%s/%s?uploadId=%s`, timeStr, timeStr, req.Bucket, req.ObjKey, req.UploadId),
+		"task_id": req.TaskId,
+	}
+	var resp RespData[FileUpAuth]
+	r := c.sessionClient.R().SetContext(ctx)
+	r.SetSuccessResult(&resp)
+	r.SetErrorResult(&resp)
+	r.SetBody(data)
+	response, err := r.Post("/file/upload/auth")
+	if err != nil {
+		return err
+	}
+	if response.IsErrorState() || resp.Code != 0 {
+		return newAPIError(response, resp.Code, resp.Msg)
+	}
+
+	r = c.defaultClient.R().SetContext(ctx)
+	u := c.ossUrl(req.Bucket, req.UploadUrl, req.ObjKey)
+	res, err := r.
+		SetHeaders(map[string]string{
+			"Authorization":    resp.Data.AuthKey,
+			"Referer":          "https://pan.quark.cn/",
+			"x-oss-date":       timeStr,
+			"x-oss-user-agent": "aliyun-sdk-js/6.6.1 Chrome 98.0.4758.80 on Windows 10 64-bit",
+		}).
+		SetQueryParams(map[string]string{
+			"uploadId": req.UploadId,
+		}).Delete(u)
+	if err != nil {
+		return err
+	}
+	// 204 取消成功，404 NoSuchUpload 会话已过期或已完成
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return newOSSError(res)
 	}
 	return nil
 }
 
 func (c *QuarkClient) FileUpFinish(req FileUpFinishReq) (*Resp, error) {
-	r := c.sessionClient.R()
+	return c.FileUpFinishCtx(context.Background(), req)
+}
+
+func (c *QuarkClient) FileUpFinishCtx(ctx context.Context, req FileUpFinishReq) (*Resp, error) {
+	r := c.sessionClient.R().SetContext(ctx)
 	var result Resp
 	r.SetSuccessResult(&result)
 	r.SetErrorResult(&result)
@@ -395,43 +585,56 @@ func (c *QuarkClient) FileUpFinish(req FileUpFinishReq) (*Resp, error) {
 		return nil, err
 	}
 	if response.IsErrorState() {
-		return nil, fmt.Errorf("code: %d, msg: %s", result.Code, result.Msg)
+		return nil, newAPIError(response, result.Code, result.Msg)
 	}
 	if result.Status >= 400 || result.Code != 0 {
-		return nil, fmt.Errorf("code: %d, msg: %s", result.Code, result.Msg)
+		return nil, newAPIError(response, result.Code, result.Msg)
 	}
 	return &result, nil
 }
 
 func (c *QuarkClient) FileDownload(fileId string) (*RespData[[]DownloadData], error) {
-	r := c.sessionClient.R()
-	data := map[string]any{
-		"fids": []string{fileId},
-	}
-	var successResult RespData[[]DownloadData]
-	var errorResult Resp
-	r.SetSuccessResult(&successResult)
-	r.SetErrorResult(&errorResult)
-	response, err := r.SetBody(data).Post("/file/download")
-	if err != nil {
-		return nil, err
-	}
-	if response.IsErrorState() {
-		return nil, fmt.Errorf("code: %d, msg: %s", errorResult.Code, errorResult.Msg)
-	}
-	if successResult.Status >= 400 || successResult.Code != 0 {
-		return nil, fmt.Errorf("code: %d, msg: %s", successResult.Code, successResult.Msg)
-	}
+	return c.FileDownloadCtx(context.Background(), fileId)
+}
 
-	return &successResult, nil
+func (c *QuarkClient) FileDownloadCtx(ctx context.Context, fileId string) (*RespData[[]DownloadData], error) {
+	return retry(ctx, c.opts.retry, func() (*RespData[[]DownloadData], error) {
+		r := c.sessionClient.R().SetContext(ctx)
+		data := map[string]any{
+			"fids": []string{fileId},
+		}
+		var successResult RespData[[]DownloadData]
+		var errorResult Resp
+		r.SetSuccessResult(&successResult)
+		r.SetErrorResult(&errorResult)
+		response, err := r.SetBody(data).Post("/file/download")
+		if err != nil {
+			return nil, err
+		}
+		if response.IsErrorState() {
+			return nil, newAPIError(response, errorResult.Code, errorResult.Msg)
+		}
+		if successResult.Status >= 400 || successResult.Code != 0 {
+			return nil, newAPIError(response, successResult.Code, successResult.Msg)
+		}
+
+		return &successResult, nil
+	})
 }
 
 func (c *QuarkClient) Share(req ShareReq) (string, error) {
+	return c.ShareCtx(context.Background(), req)
+}
+
+func (c *QuarkClient) ShareCtx(ctx context.Context, req ShareReq) (string, error) {
+	if c.plan(ctx, PlannedOperation{Type: PlanShare, Fids: req.FidList, Target: req.Title}) {
+		return "", nil
+	}
 	shareId := ""
 	if req.UrlType == 2 && req.Passcode == "" {
 		req.Passcode = genRandomWord()
 	}
-	r := c.sessionClient.R()
+	r := c.sessionClient.R().SetContext(ctx)
 	var successResult RespDataWithMeta[TaskDoing, TaskMeta]
 	var errorResult Resp
 	r.SetSuccessResult(&successResult)
@@ -443,10 +646,10 @@ func (c *QuarkClient) Share(req ShareReq) (string, error) {
 		return shareId, err
 	}
 	if response.IsErrorState() {
-		return shareId, fmt.Errorf("code: %d, msg: %s", errorResult.Code, errorResult.Msg)
+		return shareId, newAPIError(response, errorResult.Code, errorResult.Msg)
 	}
 	if successResult.Status >= 400 || successResult.Code != 0 {
-		return shareId, fmt.Errorf("code: %d, msg: %s", successResult.Code, successResult.Msg)
+		return shareId, newAPIError(response, successResult.Code, successResult.Msg)
 	}
 	isFinish := false
 
@@ -454,8 +657,10 @@ func (c *QuarkClient) Share(req ShareReq) (string, error) {
 		if isFinish {
 			break
 		}
-		time.Sleep(time.Duration(successResult.Metadata.TqGap) * time.Millisecond)
-		query, err := c.TaskQuery(successResult.Data.TaskId)
+		if err := sleepCtx(ctx, time.Duration(successResult.Metadata.TqGap)*time.Millisecond); err != nil {
+			return shareId, err
+		}
+		query, err := c.TaskQueryCtx(ctx, successResult.Data.TaskId)
 		if err != nil {
 			return shareId, err
 		}
@@ -468,55 +673,71 @@ func (c *QuarkClient) Share(req ShareReq) (string, error) {
 }
 
 func (c *QuarkClient) SharePassword(shareId string) (*RespData[SharePasswordData], error) {
-	r := c.sessionClient.R()
-	var successResult RespData[SharePasswordData]
-	var errorResult Resp
-	r.SetSuccessResult(&successResult)
-	r.SetErrorResult(&errorResult)
-	r.SetBody(map[string]interface{}{
-		"share_id": shareId,
-	})
-	// password
-	response, err := r.Post("/share/password")
-	if err != nil {
-		return nil, err
-	}
-	if response.IsErrorState() {
-		return nil, fmt.Errorf("code: %d, msg: %s", errorResult.Code, errorResult.Msg)
-	}
-	if successResult.Status >= 400 || successResult.Code != 0 {
-		return nil, fmt.Errorf("code: %d, msg: %s", successResult.Code, successResult.Msg)
-	}
+	return c.SharePasswordCtx(context.Background(), shareId)
+}
 
-	return &successResult, nil
+func (c *QuarkClient) SharePasswordCtx(ctx context.Context, shareId string) (*RespData[SharePasswordData], error) {
+	return retry(ctx, c.opts.retry, func() (*RespData[SharePasswordData], error) {
+		r := c.sessionClient.R().SetContext(ctx)
+		var successResult RespData[SharePasswordData]
+		var errorResult Resp
+		r.SetSuccessResult(&successResult)
+		r.SetErrorResult(&errorResult)
+		r.SetBody(map[string]interface{}{
+			"share_id": shareId,
+		})
+		// password
+		response, err := r.Post("/share/password")
+		if err != nil {
+			return nil, err
+		}
+		if response.IsErrorState() {
+			return nil, newAPIError(response, errorResult.Code, errorResult.Msg)
+		}
+		if successResult.Status >= 400 || successResult.Code != 0 {
+			return nil, newAPIError(response, successResult.Code, successResult.Msg)
+		}
+
+		return &successResult, nil
+	})
 }
 
 func (c *QuarkClient) ShareList() ([]ShareList, error) {
+	return c.ShareListCtx(context.Background())
+}
+
+func (c *QuarkClient) ShareListCtx(ctx context.Context) ([]ShareList, error) {
 	shareList := make([]ShareList, 0)
-	r := c.sessionClient.R()
 	page := 1
 	size := 100
 	query := map[string]string{
 		"_size":        strconv.Itoa(size),
 		"_fetch_total": "1",
 	}
-	var successResult RespDataWithMeta[ShareDetail, SortMeta]
-	var errorResult Resp
-	r.SetSuccessResult(&successResult)
-	r.SetErrorResult(&errorResult)
 	for {
 		query["_page"] = strconv.Itoa(page)
-		r.SetQueryParams(query)
-		response, err := r.Get("/share/mypage/detail")
+		successResult, err := retry(ctx, c.opts.retry, func() (*RespDataWithMeta[ShareDetail, SortMeta], error) {
+			r := c.sessionClient.R().SetContext(ctx)
+			var successResult RespDataWithMeta[ShareDetail, SortMeta]
+			var errorResult Resp
+			r.SetSuccessResult(&successResult)
+			r.SetErrorResult(&errorResult)
+			r.SetQueryParams(query)
+			response, err := r.Get("/share/mypage/detail")
+			if err != nil {
+				return nil, err
+			}
+			if response.IsErrorState() {
+				return nil, newAPIError(response, errorResult.Code, errorResult.Msg)
+			}
+			if successResult.Status >= 400 || successResult.Code != 0 {
+				return nil, newAPIError(response, successResult.Code, successResult.Msg)
+			}
+			return &successResult, nil
+		})
 		if err != nil {
 			return nil, err
 		}
-		if response.IsErrorState() {
-			return nil, fmt.Errorf("code: %d, msg: %s", errorResult.Code, errorResult.Msg)
-		}
-		if successResult.Status >= 400 || successResult.Code != 0 {
-			return nil, fmt.Errorf("code: %d, msg: %s", successResult.Code, successResult.Msg)
-		}
 		shareList = append(shareList, successResult.Data.List...)
 		if page*size >= successResult.Metadata.Total {
 			break
@@ -528,7 +749,14 @@ func (c *QuarkClient) ShareList() ([]ShareList, error) {
 }
 
 func (c *QuarkClient) ShareDelete(shareIds []string) error {
-	r := c.sessionClient.R()
+	return c.ShareDeleteCtx(context.Background(), shareIds)
+}
+
+func (c *QuarkClient) ShareDeleteCtx(ctx context.Context, shareIds []string) error {
+	if c.plan(ctx, PlannedOperation{Type: PlanShareDelete, Fids: shareIds}) {
+		return nil
+	}
+	r := c.sessionClient.R().SetContext(ctx)
 	var result Resp
 	r.SetSuccessResult(&result)
 	r.SetErrorResult(&result)
@@ -541,10 +769,10 @@ func (c *QuarkClient) ShareDelete(shareIds []string) error {
 		return err
 	}
 	if response.IsErrorState() {
-		return fmt.Errorf("code: %d, msg: %s", result.Code, result.Msg)
+		return newAPIError(response, result.Code, result.Msg)
 	}
 	if result.Status >= 400 || result.Code != 0 {
-		return fmt.Errorf("code: %d, msg: %s", result.Code, result.Msg)
+		return newAPIError(response, result.Code, result.Msg)
 	}
 
 	return nil
@@ -65,7 +65,7 @@ func TestDownloadFile(t *testing.T) {
 
 func TestOneStepUploadPath(t *testing.T) {
 	client := beforeClient()
-	err := client.UploadPath(OneStepUploadPathReq{
+	_, err := client.UploadPath(OneStepUploadPathReq{
 		LocalPath:  "D:/download/170",
 		RemotePath: "/170",
 		Resumable:  true,
@@ -1,48 +1,58 @@
 package quark
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"github.com/Xhofe/go-cache"
-	"github.com/imroc/req/v3"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
-var dirCache = cache.NewMemCache(cache.WithShards[[]File](100))
-
 type ProgressReader struct {
 	io.ReadCloser
-	totalSize int64
-	uploaded  int64
-	startTime time.Time
+	tracker *progressTracker
 }
 
 func (pr *ProgressReader) Read(p []byte) (n int, err error) {
 	n, err = pr.ReadCloser.Read(p)
 	if n > 0 {
-		pr.uploaded += int64(n)
-		elapsed := time.Since(pr.startTime).Seconds()
-		var speed float64
-		if elapsed == 0 {
-			speed = float64(pr.uploaded) / 1024
-		} else {
-			speed = float64(pr.uploaded) / 1024 / elapsed // KB/s
-		}
-
-		// 计算进度百分比
-		percent := float64(pr.uploaded) / float64(pr.totalSize) * 100
-		fmt.Printf("\ruploading: %.2f%% (%d/%d bytes, %.2f KB/s)", percent, pr.uploaded, pr.totalSize, speed)
-		// 相等即已经处理完毕
-		if pr.uploaded == pr.totalSize {
-			fmt.Println()
-		}
+		pr.tracker.add(int64(n))
 	}
 	return n, err
 }
 
+// Seek 回退读取位置，用于分片重传，底层Reader不支持时返回错误
+func (pr *ProgressReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := pr.ReadCloser.(io.Seeker)
+	if !ok {
+		return 0, fmt.Errorf("reader not seekable")
+	}
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	ret, err := seeker.Seek(offset, whence)
+	if err != nil {
+		return ret, err
+	}
+	pr.tracker.add(ret - current)
+	return ret, nil
+}
+
+// sectionReadCloser 为分片提供可回退的读取
+type sectionReadCloser struct {
+	*io.SectionReader
+}
+
+func (sectionReadCloser) Close() error {
+	return nil
+}
+
 func isEmpty(dirPath string) (bool, error) {
 	dir, err := os.Open(dirPath)
 	if err != nil {
@@ -57,348 +67,978 @@ func isEmpty(dirPath string) (bool, error) {
 	return false, err
 }
 
-// UploadPath 一键上传路径
-func (c *QuarkClient) UploadPath(req OneStepUploadPathReq) error {
-	dirCache.Clear()
+// UploadPath 一键上传路径，返回每个文件的上传结果，有文件失败时同时返回 *UploadPathError
+func (c *QuarkClient) UploadPath(req OneStepUploadPathReq) (*UploadReport, error) {
+	return c.UploadPathCtx(context.Background(), req)
+}
+
+func (c *QuarkClient) UploadPathCtx(ctx context.Context, req OneStepUploadPathReq) (*UploadReport, error) {
+	c.dirCache.Clear()
+	report := newUploadReport(req)
+	defer report.finish()
+	if record, ok := c.dryRun(ctx); ok || req.DryRun {
+		ctx = withDryRun(ctx, func(op PlannedOperation) {
+			report.plan(op)
+			if record != nil {
+				record(op)
+			}
+		})
+	}
+	filter, err := newFileFilter(req)
+	if err != nil {
+		return report, err
+	}
+	// 在启动上传协程前解析根目录，出错时直接返回
+	root, err := filepath.EvalSymlinks(req.LocalPath)
+	if err != nil {
+		return report, err
+	}
+	// 回收目录位于 LocalPath 内时不上传
+	trashAbs := ""
+	if req.SuccessDel && req.TrashDir != "" {
+		trashAbs, _ = filepath.Abs(req.TrashDir)
+	}
+	walkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		failed  []*FileError
+		stopped bool
+	)
+	jobs := make(chan uploadPathJob)
+	for i := 0; i < max(req.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				entry := c.uploadPathFile(walkCtx, req, job)
+				report.add(entry)
+				err := entry.Err
+				if err == nil {
+					continue
+				}
+				mu.Lock()
+				// 因前面的失败而取消的上传只记录在报告中，不计入错误
+				if !stopped || !errors.Is(err, context.Canceled) {
+					c.logger.Error("upload file failed", "path", job.path, "err", err)
+					failed = append(failed, &FileError{Path: job.path, Err: err})
+				}
+				// 没有读取权限的文件不影响其他文件
+				if !req.SkipFileErr && !errors.Is(err, os.ErrPermission) {
+					stopped = true
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	skipEntry := func(path string, info os.FileInfo, reason string) {
+		c.logger.Debug("skip file", "path", path, "reason", reason)
+		report.add(UploadReportEntry{Path: path, Outcome: OutcomeSkippedUnsupported, Reason: reason, Size: info.Size()})
+	}
+	// 无法读取的文件或目录记录为失败，不影响其他文件
+	walkFailed := func(path string, err error) error {
+		c.logger.Error("walk failed", "path", path, "err", err)
+		report.add(UploadReportEntry{Path: path, Outcome: OutcomeFailed, Err: err})
+		mu.Lock()
+		failed = append(failed, &FileError{Path: path, Err: err})
+		mu.Unlock()
+		return nil
+	}
+	// 忽略文件无法读取时跳过所在目录，避免上传本应忽略的文件
+	loadIgnore := func(path, rel string) error {
+		if err := filter.loadIgnoreFile(path, rel); err != nil {
+			walkFailed(filepath.Join(path, filter.ignoreFile), err)
+			return filepath.SkipDir
+		}
+		return nil
+	}
 	// 遍历目录
-	err := filepath.Walk(req.LocalPath, func(path string, info os.FileInfo, err error) error {
+	err = walkTree(root, req.LocalPath, req.Symlinks, nil, func(path string, info os.FileInfo, err error) error {
+		if ctxErr := walkCtx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if errors.Is(err, errSymlinkLoop) {
+			skipEntry(path, info, "symlink loop")
+			return nil
+		}
 		if err != nil {
-			return err
+			if info != nil && info.IsDir() {
+				walkFailed(path, err)
+				return filepath.SkipDir
+			}
+			return walkFailed(path, err)
 		}
+		// 获取相对于root的相对路径
+		rel, _ := filepath.Rel(req.LocalPath, path)
+		rel = filepath.ToSlash(rel)
 		if info.IsDir() {
-			for _, ignorePath := range req.IgnorePaths {
-				if filepath.Base(path) == ignorePath {
-					return filepath.SkipDir
-				}
+			if rel == "." {
+				return loadIgnore(path, "")
 			}
-		} else {
-			// 获取相对于root的相对路径
-			relPath, _ := filepath.Rel(req.LocalPath, path)
-			relPath = strings.Replace(relPath, "\\", "/", -1)
-			relPath = strings.Replace(relPath, info.Name(), "", 1)
-			NotUpload := false
-			for _, ignoreFile := range req.IgnoreFiles {
-				if info.Name() == ignoreFile {
-					NotUpload = true
-					break
-				}
+			if trashAbs != "" && sameFile(path, trashAbs) {
+				return filepath.SkipDir
 			}
-			for _, extension := range req.IgnoreExtensions {
-				if strings.HasSuffix(info.Name(), extension) {
-					NotUpload = true
-					break
-				}
+			if skip, reason := filter.skipDir(rel, info); skip {
+				c.logger.Debug("skip dir", "path", path, "reason", reason)
+				report.add(UploadReportEntry{Path: path, Dir: true, Outcome: OutcomeSkippedByFilter, Reason: reason})
+				return filepath.SkipDir
 			}
-			for _, extension := range req.Extensions {
-				if strings.HasSuffix(info.Name(), extension) {
-					NotUpload = false
-					break
+			return loadIgnore(path, rel)
+		}
+		job := uploadPathJob{path: path, relPath: strings.TrimSuffix(rel, info.Name()), info: info}
+		if info.Mode()&os.ModeSymlink != 0 {
+			switch req.Symlinks {
+			case SymlinkSkip:
+				skipEntry(path, info, "symlink")
+				return nil
+			case SymlinkAsFile:
+				if job.link, err = os.Readlink(path); err != nil {
+					return walkFailed(path, err)
 				}
-				NotUpload = true
-			}
-			if !NotUpload {
-				err = c.UploadFile(OneStepUploadFileReq{
-					LocalFile:      path,
-					RemotePath:     strings.TrimRight(req.RemotePath, "/") + "/" + relPath,
-					Resumable:      req.Resumable,
-					SuccessDel:     req.SuccessDel,
-					RemoteTransfer: req.RemoteTransfer,
-				})
-				if err == nil {
-					if req.SuccessDel {
-						dir := filepath.Dir(path)
-						if dir != "." {
-							empty, _ := isEmpty(dir)
-							if empty {
-								_ = os.Remove(dir)
-								fmt.Println("uploaded success and delete", dir)
-							}
-						}
-					}
-				} else {
-					if !req.SkipFileErr {
-						return err
-					} else {
-						fmt.Println("upload err", err)
-					}
+			default:
+				// 指向目录的链接在 SymlinkFollow 时已由 walkTree 处理
+				target, err := os.Stat(path)
+				if err != nil {
+					return walkFailed(path, err)
 				}
+				if target.IsDir() {
+					skipEntry(path, info, "symlink to directory")
+					return nil
+				}
+				job.info = target
 			}
 		}
-		return nil
+		if reason := specialFileReason(job.info.Mode()); reason != "" {
+			skipEntry(path, info, reason)
+			return nil
+		}
+		if skip, reason := filter.skipFile(rel, job.info); skip {
+			c.logger.Debug("skip file", "path", path, "reason", reason)
+			report.add(UploadReportEntry{Path: path, Outcome: OutcomeSkippedByFilter, Reason: reason, Size: job.info.Size()})
+			return nil
+		}
+		select {
+		case jobs <- job:
+			return nil
+		case <-walkCtx.Done():
+			return walkCtx.Err()
+		}
+	})
+	close(jobs)
+	wg.Wait()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return report, ctxErr
+	}
+	if len(failed) > 0 {
+		return report, &UploadPathError{Files: failed}
+	}
+	return report, err
+}
+
+// uploadPathJob UploadPath 遍历出的待上传文件，relPath为所在目录相对于根目录的路径
+type uploadPathJob struct {
+	path    string
+	relPath string
+	info    os.FileInfo
+	// link 不为空时把链接目标作为文件内容上传
+	link string
+}
+
+// uploadPathFile 上传遍历出的单个文件，失败时 entry.Err 不为空
+func (c *QuarkClient) uploadPathFile(ctx context.Context, req OneStepUploadPathReq, job uploadPathJob) UploadReportEntry {
+	start := time.Now()
+	entry := UploadReportEntry{Path: job.path, Size: job.info.Size()}
+	remotePath := strings.TrimRight(req.RemotePath, "/") + "/" + job.relPath
+	if job.link != "" {
+		// 链接本身不会被删除
+		name := job.info.Name() + symlinkFileSuffix
+		fid, err := c.UploadReader(ctx, strings.NewReader(job.link), int64(len(job.link)), remotePath, name, UploadReaderOpts{
+			Progress:        req.Progress,
+			PartConcurrency: req.PartConcurrency,
+			ModTime:         job.info.ModTime(),
+		})
+		entry.Duration = time.Since(start)
+		entry.RemotePath = remotePath + name
+		entry.Size = int64(len(job.link))
+		entry.Fid = fid
+		switch _, dryRun := c.dryRun(ctx); {
+		case err != nil:
+			entry.Outcome = OutcomeFailed
+			entry.Err = err
+		case dryRun:
+			entry.Outcome = OutcomeDryRun
+		default:
+			entry.Outcome = OutcomeUploaded
+			entry.Bytes = entry.Size
+		}
+		return entry
+	}
+	// 回收目录中保留原有的目录结构
+	trashDir := req.TrashDir
+	if trashDir != "" {
+		trashDir = filepath.Join(trashDir, filepath.FromSlash(job.relPath))
+	}
+	result, err := c.uploadFile(ctx, OneStepUploadFileReq{
+		LocalFile:       job.path,
+		RemotePath:      remotePath,
+		Resumable:       req.Resumable,
+		SuccessDel:      req.SuccessDel,
+		VerifyBeforeDel: req.VerifyBeforeDel,
+		TrashDir:        trashDir,
+		RemoteTransfer:  req.RemoteTransfer,
+		Progress:        req.Progress,
+		PartConcurrency: req.PartConcurrency,
+		HashCache:       req.HashCache,
+		ConflictPolicy:  req.ConflictPolicy,
+		RapidOnly:       req.RapidOnly,
 	})
+	entry.Duration = time.Since(start)
+	entry.RemotePath = result.remotePath
+	entry.Fid = result.fid
+	entry.Bytes = result.bytes
+	if errors.Is(err, ErrRapidUploadMiss) {
+		if req.RapidMissCallback != nil {
+			req.RapidMissCallback(job.path, job.info.Size())
+		}
+		entry.Outcome = OutcomeRapidMiss
+		return entry
+	}
 	if err != nil {
-		return err
+		entry.Outcome = OutcomeFailed
+		entry.Err = err
+		return entry
 	}
-	return nil
+	entry.Outcome = result.outcome
+	if req.SuccessDel && entry.Outcome != OutcomeDryRun && entry.Outcome != OutcomeSkippedExisting {
+		for _, dir := range cleanEmptyParents(filepath.Dir(job.path), req.LocalPath) {
+			c.logger.Info("uploaded success and delete", "path", dir)
+		}
+	}
+	return entry
 }
 
 // UploadFile 一键上传文件
 func (c *QuarkClient) UploadFile(req OneStepUploadFileReq) error {
+	return c.UploadFileCtx(context.Background(), req)
+}
+
+func (c *QuarkClient) UploadFileCtx(ctx context.Context, req OneStepUploadFileReq) error {
+	if req.DryRun {
+		record, _ := c.dryRun(ctx)
+		ctx = withDryRun(ctx, record)
+	}
+	_, err := c.uploadFile(c.dryRunCtx(ctx), req)
+	return err
+}
 
-	md5Str, err := getFileMd5(req.LocalFile)
+// TryRapidUpload 只尝试秒传，秒传失败时不会传输任何数据
+func (c *QuarkClient) TryRapidUpload(localFile, remotePath string) (*RapidUploadResult, error) {
+	return c.TryRapidUploadCtx(context.Background(), localFile, remotePath)
+}
+
+func (c *QuarkClient) TryRapidUploadCtx(ctx context.Context, localFile, remotePath string) (*RapidUploadResult, error) {
+	stat, err := os.Stat(localFile)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	result := &RapidUploadResult{
+		LocalFile:  localFile,
+		RemotePath: remotePath,
+		Size:       stat.Size(),
+	}
+	uploaded, err := c.uploadFile(ctx, OneStepUploadFileReq{
+		LocalFile:  localFile,
+		RemotePath: remotePath,
+		RapidOnly:  true,
+	})
+	if errors.Is(err, ErrRapidUploadMiss) {
+		return result, nil
 	}
-	sha1Str, err := getFileSha1(req.LocalFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	result.Hit = true
+	result.Fid = uploaded.fid
+	return result, nil
+}
 
-	mimeType := getMimeType(req.LocalFile)
+// uploadResult 单个文件的上传结果
+type uploadResult struct {
+	fid     string
+	outcome UploadOutcome
+	// remotePath 网盘中的完整路径
+	remotePath string
+	// bytes 实际传输的字节数
+	bytes int64
+}
 
+// uploadFile 上传本地文件，跳过时fid为空
+func (c *QuarkClient) uploadFile(ctx context.Context, req OneStepUploadFileReq) (result uploadResult, err error) {
 	file, err := os.Open(req.LocalFile)
 	if err != nil {
-		return err
+		return result, err
 	}
 	defer file.Close()
 	stat, err := file.Stat()
 	if err != nil {
-		return err
+		return result, err
 	}
+	tracker := newProgressTracker(req.Progress, c.logger, req.LocalFile, "", stat.Size(), 0)
+	tracker.emit(EventFileStarted, 0, nil)
+	defer func() {
+		if err != nil && !errors.Is(err, ErrRapidUploadMiss) {
+			tracker.emit(EventFileFailed, 0, err)
+		}
+	}()
+
+	// 演练时只有 ConflictSkip 需要MD5判断是否跳过，计算哈希不会修改任何文件
+	_, dryRun := c.dryRun(ctx)
+	var hash FileHash
+	if !dryRun || req.ConflictPolicy == ConflictSkip {
+		if hash, err = c.fileHash(ctx, req.LocalFile, stat, req.HashCache); err != nil {
+			return result, err
+		}
+	}
+
 	remoteName := stat.Name()
 	remotePath := req.RemotePath
 	if req.RemoteTransfer != nil {
 		remoteName, remotePath = req.RemoteTransfer(remoteName, remotePath)
 	}
-	dirId, err := c.FileId(remotePath, true, true)
+	// 只尝试秒传时不创建目录，目录不存在即秒传失败
+	dirId, err := c.FileIdCtx(ctx, remotePath, true, !req.RapidOnly)
+	if req.RapidOnly && errors.Is(err, ErrNotFound) {
+		return result, fmt.Errorf("%w: %w", ErrRapidUploadMiss, err)
+	}
 	if err != nil {
-		return err
+		return result, err
+	}
+	conflict, err := c.resolveConflict(ctx, req.ConflictPolicy, dirId, remoteName, stat.Size(), hash)
+	if err != nil {
+		return result, err
 	}
-	md5Key := md5Hash(req.LocalFile + remotePath + dirId)
-	var pre RespDataWithMeta[FileUpPre, FileUpPreMeta]
-	if req.Resumable {
-		cacheErr := GetCache("session_"+md5Key, &pre)
-		if cacheErr != nil {
-			fmt.Println("cache err:", cacheErr)
+	if conflict.replace == "" {
+		remoteName = conflict.name
+	}
+	result.remotePath = strings.TrimRight(remotePath, "/") + "/" + remoteName
+	if conflict.skip {
+		c.logger.Info("skip existing file", "path", req.LocalFile, "remote", result.remotePath)
+		result.outcome = OutcomeSkippedExisting
+		return result, nil
+	}
+	if c.plan(ctx, PlannedOperation{Type: PlanUpload, Path: req.LocalFile, Target: result.remotePath, Size: stat.Size()}) {
+		if conflict.replace != "" {
+			c.plan(ctx, PlannedOperation{Type: PlanDelete, Fids: []string{conflict.replace}})
+		}
+		if req.SuccessDel {
+			c.plan(ctx, PlannedOperation{Type: PlanDeleteLocal, Path: req.LocalFile, Size: stat.Size()})
+		}
+		result.outcome = OutcomeDryRun
+		return result, nil
+	}
+	src := uploadSource{
+		reader:      file,
+		size:        stat.Size(),
+		name:        conflict.name,
+		dirId:       dirId,
+		mimeType:    getMimeType(req.LocalFile),
+		hash:        hash,
+		modTime:     stat.ModTime(),
+		createdAt:   fileCreatedAt(stat),
+		concurrency: req.PartConcurrency,
+		tracker:     tracker,
+		rapidOnly:   req.RapidOnly,
+	}
+	if req.Resumable && !req.RapidOnly {
+		src.resumeKey = md5Hash(req.LocalFile + remotePath + dirId)
+		src.localFile = req.LocalFile
+		src.remotePath = remotePath
+	}
+	uploaded, err := c.upload(ctx, src)
+	if err != nil {
+		return result, err
+	}
+	result.fid, result.outcome, result.bytes = uploaded.fid, uploaded.outcome, uploaded.bytes
+	if conflict.replace != "" {
+		if err = c.replaceUploaded(ctx, dirId, conflict.replace, result.fid, remoteName); err != nil {
+			return result, err
+		}
+	}
+	c.cacheUploaded(dirId, File{
+		Fid:        result.fid,
+		FileName:   remoteName,
+		PdirFid:    dirId,
+		Size:       int(stat.Size()),
+		File:       true,
+		LCreatedAt: src.createdAt.UnixMilli(),
+		LUpdatedAt: src.modTime.UnixMilli(),
+	})
+	// 上传成功则移除文件了
+	if req.SuccessDel {
+		if req.VerifyBeforeDel {
+			if err = c.verifyRemote(ctx, result.fid, stat.Size(), hash.Md5); err != nil {
+				return result, err
+			}
+		}
+		_ = file.Close()
+		trashed, err := removeLocal(req.LocalFile, req.TrashDir)
+		if err != nil {
+			return result, fmt.Errorf("uploaded but failed to remove local file: %w", err)
+		}
+		c.logger.Info("uploaded success and delete", "path", req.LocalFile, "trash", trashed)
+	}
+	return result, nil
+}
+
+// UploadReader 上传任意数据流，size必须与数据长度一致，返回网盘文件的fid。
+// 数据源实现了 io.ReaderAt 时直接分片读取，否则先缓存到内存或临时文件再上传。
+// size为负数表示长度未知，此时总是先缓存到临时文件，EventFileStarted 的 Total 为负数
+func (c *QuarkClient) UploadReader(ctx context.Context, r io.Reader, size int64, remotePath, name string, opts UploadReaderOpts) (fid string, err error) {
+	ctx = c.dryRunCtx(ctx)
+	tracker := newProgressTracker(opts.Progress, c.logger, name, "", size, 0)
+	tracker.emit(EventFileStarted, 0, nil)
+	defer func() {
+		if err != nil {
+			tracker.emit(EventFileFailed, 0, err)
+		}
+	}()
+
+	hash := FileHash{Md5: opts.Md5, Sha1: opts.Sha1}
+	readerAt, ok := r.(io.ReaderAt)
+	if ok && size >= 0 {
+		if hash.Md5 == "" || hash.Sha1 == "" {
+			if hash, err = readerAtHash(ctx, readerAt, size); err != nil {
+				return "", err
+			}
+		}
+	} else {
+		spoolMemory := opts.SpoolMemory
+		if spoolMemory <= 0 {
+			spoolMemory = defaultSpoolMemory
 		}
+		var spooled FileHash
+		var cleanup func()
+		readerAt, size, spooled, cleanup, err = spool(ctx, r, size, spoolMemory, opts.SpoolDir)
+		if err != nil {
+			return "", err
+		}
+		defer cleanup()
+		tracker.setTotal(size)
+		if hash.Md5 == "" || hash.Sha1 == "" {
+			hash = spooled
+		}
+	}
+
+	dirId, err := c.FileIdCtx(ctx, remotePath, true, true)
+	if err != nil {
+		return "", err
+	}
+	mimeType := opts.MimeType
+	if mimeType == "" {
+		mimeType = getMimeType(name)
+	}
+	result, err := c.upload(ctx, uploadSource{
+		reader:      readerAt,
+		size:        size,
+		name:        name,
+		dirId:       dirId,
+		mimeType:    mimeType,
+		hash:        hash,
+		modTime:     opts.ModTime,
+		createdAt:   opts.CreatedAt,
+		concurrency: opts.PartConcurrency,
+		tracker:     tracker,
+	})
+	return result.fid, err
+}
+
+// uploadSource 一次上传的数据及参数
+type uploadSource struct {
+	reader   io.ReaderAt
+	size     int64
+	name     string
+	dirId    string
+	mimeType string
+	hash     FileHash
+	// resumeKey 不为空时保存断点续传的状态
+	resumeKey string
+	// modTime createdAt 本地文件的修改和创建时间，零值时使用当前时间
+	modTime   time.Time
+	createdAt time.Time
+	// localFile、remotePath、modTime 记录在续传清单中，用于校验文件是否变化
+	localFile   string
+	remotePath  string
+	concurrency int
+	tracker     *progressTracker
+	// rapidOnly 只尝试秒传，失败时返回 ErrRapidUploadMiss
+	rapidOnly bool
+}
+
+// upload 上传src，续传的会话在服务端已失效时自动重新开始
+func (c *QuarkClient) upload(ctx context.Context, src uploadSource) (uploadResult, error) {
+	if c.plan(ctx, PlannedOperation{Type: PlanUpload, Path: src.tracker.path, Target: src.name, Fids: []string{src.dirId}, Size: src.size}) {
+		return uploadResult{outcome: OutcomeDryRun}, nil
 	}
-	if pre.Data.TaskId == "" {
+	var manifest *uploadManifest
+	if src.resumeKey != "" {
+		manifest = c.loadManifest(src)
+	}
+	result, err := c.uploadSession(ctx, src, manifest)
+	if err != nil && manifest != nil && staleSession(err) {
+		c.logger.Info("resume session expired, restart upload", "path", src.tracker.path, "err", err)
+		_ = c.delState(manifestKey(src.resumeKey))
+		result, err = c.uploadSession(ctx, src, nil)
+	}
+	return result, err
+}
+
+// uploadSession 依次执行 pre、hash、分片上传、commit、finish，manifest不为空时从中恢复
+func (c *QuarkClient) uploadSession(ctx context.Context, src uploadSource, manifest *uploadManifest) (uploadResult, error) {
+	tracker := src.tracker
+	path := tracker.path
+	resumable := src.resumeKey != ""
+	if manifest == nil {
 		// pre
-		resp, err := c.FileUploadPre(FileUpPreReq{
-			ParentId: dirId,
-			FileName: remoteName,
-			FileSize: stat.Size(),
-			MimeType: mimeType,
+		resp, err := c.FileUploadPreCtx(ctx, FileUpPreReq{
+			ParentId:  src.dirId,
+			FileName:  src.name,
+			FileSize:  src.size,
+			MimeType:  src.mimeType,
+			CreatedAt: src.createdAt,
+			UpdatedAt: src.modTime,
 		})
 		if err != nil {
-			return err
+			return uploadResult{}, err
 		}
-		pre = *resp
-		cacheErr := SetCache("session_"+md5Key, pre)
-		if cacheErr != nil {
-			fmt.Println("cache err:", cacheErr)
+		c.logger.Debug("upload pre", "path", path, "task_id", resp.Data.TaskId)
+		manifest = newUploadManifest(src, *resp)
+		if resumable {
+			c.saveManifest(src.resumeKey, manifest)
 		}
+	} else {
+		c.logger.Info("resume upload", "path", path, "task_id", manifest.Pre.Data.TaskId, "parts", len(manifest.Parts))
 	}
+	pre := manifest.Pre
 
 	// hash
-	finish, err := c.FileUploadHash(FileUpHashReq{
-		Md5:    md5Str,
-		Sha1:   sha1Str,
+	finish, err := c.FileUploadHashCtx(ctx, FileUpHashReq{
+		Md5:    src.hash.Md5,
+		Sha1:   src.hash.Sha1,
 		TaskId: pre.Data.TaskId,
 	})
 	if err != nil {
-		return err
+		return uploadResult{}, err
 	}
 	if finish.Data.Finish {
-		return nil
-	}
-
-	uploadedSize := 0
-	md5s := make([]string, 0)
-	if req.Resumable {
-		cacheErr := GetCache("chunk_"+md5Key, &uploadedSize)
-		if cacheErr != nil {
-			fmt.Println("cache err:", cacheErr)
-		}
-		var md5Strs string
-		cacheErr = GetCache("md5s_"+md5Key, &md5Strs)
-		if cacheErr != nil {
-			fmt.Println("cache err:", cacheErr)
-		}
-		if md5Strs != "" {
-			md5s = strings.Split(md5Strs, ",")
+		c.logger.Info("rapid upload success", "path", path, "fid", finish.Data.Fid)
+		tracker.setFid(finish.Data.Fid)
+		tracker.emit(EventRapidUpload, 0, nil)
+		if resumable {
+			_ = c.delState(manifestKey(src.resumeKey))
 		}
+		return uploadResult{fid: finish.Data.Fid, outcome: OutcomeRapidUploaded}, nil
 	}
-	// part up
-	partSize := pre.Metadata.PartSize
-	total := stat.Size()
-	left := total - int64(uploadedSize)
-	if uploadedSize > 0 {
-		// 将文件指针移动到指定的分片位置
-		ret, _ := file.Seek(int64(uploadedSize), 0)
-		if ret == 0 {
-			return fmt.Errorf("seek file failed")
-		}
-	}
-	partNumber := (uploadedSize / partSize) + 1
-	pr := &ProgressReader{
-		startTime: time.Now(),
-		totalSize: total,
-		uploaded:  int64(uploadedSize),
-	}
-	for left > 0 {
-		pr.ReadCloser = io.NopCloser(&io.LimitedReader{
-			R: file,
-			N: int64(partSize),
-		})
-		chunkUploadSize := min(total-int64((partNumber-1)*partSize), int64(partSize))
-		left -= chunkUploadSize
-		m, err := c.FileUpPart(FileUpPartReq{
-			ObjKey:     pre.Data.ObjKey,
-			Bucket:     pre.Data.Bucket,
-			UploadId:   pre.Data.UploadId,
-			AuthInfo:   pre.Data.AuthInfo,
-			UploadUrl:  pre.Data.UploadUrl,
-			MineType:   mimeType,
-			PartNumber: partNumber,
-			TaskId:     pre.Data.TaskId,
-			Reader:     pr,
+	if src.rapidOnly {
+		c.logger.Info("rapid upload miss", "path", path, "size", src.size)
+		// 不再上传，取消pre创建的分片上传
+		err = c.FileUpAbortCtx(ctx, FileUpAbortReq{
+			ObjKey:    pre.Data.ObjKey,
+			Bucket:    pre.Data.Bucket,
+			UploadId:  pre.Data.UploadId,
+			AuthInfo:  pre.Data.AuthInfo,
+			UploadUrl: pre.Data.UploadUrl,
+			TaskId:    pre.Data.TaskId,
 		})
 		if err != nil {
-			return err
-		}
-		if m == "finish" {
-			return nil
+			c.logger.Warn("abort upload failed", "path", path, "task_id", pre.Data.TaskId, "err", err)
 		}
-		md5s = append(md5s, m)
-		if req.Resumable {
-			cacheErr := SetCache("chunk_"+md5Key, int64(uploadedSize)+chunkUploadSize)
-			if cacheErr != nil {
-				fmt.Println("cache err:", cacheErr)
-			}
-			cacheErr = SetCache("md5s_"+md5Key, strings.Join(md5s, ","))
-			if cacheErr != nil {
-				fmt.Println("cache err:", cacheErr)
+		return uploadResult{}, ErrRapidUploadMiss
+	}
+
+	// part up
+	concurrency := src.concurrency
+	if concurrency <= 0 {
+		concurrency = pre.Metadata.PartThread
+	}
+	tracker.resume(manifest.doneBytes())
+	md5s, err := c.uploadParts(ctx, partUpload{
+		file:        src.reader,
+		pre:         pre.Data,
+		mimeType:    src.mimeType,
+		partSize:    manifest.PartSize,
+		total:       src.size,
+		done:        manifest.completedParts(),
+		concurrency: concurrency,
+		tracker:     tracker,
+		saved: func(partNumber int, etag string) {
+			if !resumable {
+				return
 			}
-		}
-		partNumber++
+			manifest.Parts[partNumber] = etag
+			c.saveManifest(src.resumeKey, manifest)
+		},
+	})
+	if err != nil {
+		return uploadResult{}, err
 	}
-	err = c.FileUpCommit(FileUpCommitReq{
+	err = c.FileUpCommitCtx(ctx, FileUpCommitReq{
 		ObjKey:    pre.Data.ObjKey,
 		Bucket:    pre.Data.Bucket,
 		UploadId:  pre.Data.UploadId,
 		AuthInfo:  pre.Data.AuthInfo,
 		UploadUrl: pre.Data.UploadUrl,
-		MineType:  mimeType,
+		MineType:  src.mimeType,
 		TaskId:    pre.Data.TaskId,
 		Callback:  pre.Data.Callback,
 	}, md5s)
 	if err != nil {
-		return err
+		return uploadResult{}, err
 	}
-	_, err = c.FileUpFinish(FileUpFinishReq{
+	_, err = c.FileUpFinishCtx(ctx, FileUpFinishReq{
 		ObjKey: pre.Data.ObjKey,
 		TaskId: pre.Data.TaskId,
 	})
 	if err != nil {
-		return err
+		return uploadResult{}, err
 	}
-	if req.Resumable {
-		_ = DelCache("session_" + md5Key)
-		_ = DelCache("chunk_" + md5Key)
-		_ = DelCache("md5s_" + md5Key)
+	tracker.setFid(pre.Data.Fid)
+	tracker.emit(EventFileFinished, 0, nil)
+	if resumable {
+		_ = c.delState(manifestKey(src.resumeKey))
 	}
-	// 上传成功则移除文件了
-	if req.SuccessDel {
-		_ = os.Remove(req.LocalFile)
-		fmt.Println("uploaded success and delete", req.LocalFile)
+	return uploadResult{fid: pre.Data.Fid, outcome: OutcomeUploaded, bytes: tracker.sent()}, nil
+}
+
+// partUpload 分片上传的参数
+type partUpload struct {
+	file     io.ReaderAt
+	pre      FileUpPre
+	mimeType string
+	partSize int64
+	total    int64
+	// done 断点续传时已完成的分片，key为分片序号
+	done        map[int]string
+	concurrency int
+	tracker     *progressTracker
+	// saved 每个分片完成时调用，用于保存断点续传的状态，调用之间不会并发
+	saved func(partNumber int, etag string)
+}
+
+// uploadParts 并发上传分片，返回按分片顺序排列的ETag
+func (c *QuarkClient) uploadParts(ctx context.Context, up partUpload) ([]string, error) {
+	if up.partSize <= 0 {
+		return nil, fmt.Errorf("invalid part size: %d", up.partSize)
 	}
-	return nil
+	partCount := int((up.total + up.partSize - 1) / up.partSize)
+	etags := make([]string, partCount)
+	for partNumber, etag := range up.done {
+		if partNumber >= 1 && partNumber <= partCount {
+			etags[partNumber-1] = etag
+		}
+	}
+	concurrency := max(up.concurrency, 1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	parts := make(chan int)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for partNumber := range parts {
+				offset := int64(partNumber-1) * up.partSize
+				size := min(up.partSize, up.total-offset)
+				etag, err := c.FileUpPartCtx(ctx, FileUpPartReq{
+					ObjKey:     up.pre.ObjKey,
+					Bucket:     up.pre.Bucket,
+					UploadId:   up.pre.UploadId,
+					AuthInfo:   up.pre.AuthInfo,
+					UploadUrl:  up.pre.UploadUrl,
+					MineType:   up.mimeType,
+					PartNumber: partNumber,
+					TaskId:     up.pre.TaskId,
+					Reader: &ProgressReader{
+						ReadCloser: sectionReadCloser{io.NewSectionReader(up.file, offset, size)},
+						tracker:    up.tracker,
+					},
+				})
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					mu.Unlock()
+					continue
+				}
+				etags[partNumber-1] = etag
+				if up.saved != nil {
+					up.saved(partNumber, etag)
+				}
+				mu.Unlock()
+				c.logger.Debug("uploaded part", "path", up.tracker.path, "part", partNumber, "task_id", up.pre.TaskId)
+				up.tracker.emit(EventPartCompleted, partNumber, nil)
+			}
+		}()
+	}
+
+feed:
+	for partNumber := 1; partNumber <= partCount; partNumber++ {
+		if etags[partNumber-1] != "" {
+			continue
+		}
+		select {
+		case parts <- partNumber:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(parts)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return etags, nil
 }
 
 func (c *QuarkClient) DownloadFile(object File, localPath string, downloadCallback DownloadCallback) error {
-	fmt.Println("start download file", object.FileName)
-	outputFile := localPath + "/" + object.FileName
-	resp, err := c.FileDownload(object.Fid)
+	return c.DownloadFileCtx(context.Background(), object, localPath, downloadCallback)
+}
+
+func (c *QuarkClient) DownloadFileCtx(ctx context.Context, object File, localPath string, downloadCallback DownloadCallback) error {
+	return c.DownloadCtx(ctx, OneStepDownloadFileReq{
+		Object:    object,
+		LocalPath: localPath,
+		Callback:  downloadCallback,
+	})
+}
+
+// Download 一键下载文件
+func (c *QuarkClient) Download(req OneStepDownloadFileReq) error {
+	return c.DownloadCtx(context.Background(), req)
+}
+
+func (c *QuarkClient) DownloadCtx(ctx context.Context, req OneStepDownloadFileReq) (err error) {
+	object := req.Object
+	if object.Ban {
+		return fmt.Errorf("%w:%s", ErrFileBanned, object.FileName)
+	}
+	c.logger.Info("start download file", "fid", object.Fid, "name", object.FileName)
+	outputFile := req.LocalPath + "/" + object.FileName
+	totalSize := int64(object.Size)
+	tracker := newProgressTracker(req.Progress, c.logger, outputFile, object.Fid, totalSize, 0)
+	tracker.emit(EventFileStarted, 0, nil)
+	defer func() {
+		if err != nil {
+			tracker.emit(EventFileFailed, 0, err)
+		}
+	}()
+	resp, err := c.FileDownloadCtx(ctx, object.Fid)
 	if err != nil {
 		return err
 	}
-	downloadUrl := resp.Data[0].DownloadUrl
-	err = os.MkdirAll(localPath, os.ModePerm)
+	if len(resp.Data) == 0 {
+		return fmt.Errorf("%w:%s", ErrNotFound, object.Fid)
+	}
+	// 分段、续传日志和校验都以下载接口返回的大小和MD5为准，Size为0时视为调用方不知道大小
+	remote := resp.Data[0]
+	if object.Size > 0 && object.Size != remote.Size {
+		return fmt.Errorf("%w: %s size %d, remote %d", ErrFileChanged, object.Fid, object.Size, remote.Size)
+	}
+	object.Size = remote.Size
+	totalSize = int64(remote.Size)
+	tracker.setTotal(totalSize)
+	downloadUrl := remote.DownloadUrl
+	err = os.MkdirAll(req.LocalPath, os.ModePerm)
 	if err != nil {
 		return err
 	}
-	totalSize := int64(object.Size)
-	downloaded := int64(0)
-
-	startTime := time.Now()
-	callback := func(info req.DownloadInfo) {
-		if info.Response.Response != nil {
-
-			thisDownload := info.DownloadedSize
-			calDownloaded := thisDownload + downloaded
-			elapsed := time.Since(startTime).Seconds()
-			var speed float64
-			if elapsed == 0 {
-				speed = float64(calDownloaded) / 1024
-			} else {
-				speed = float64(calDownloaded) / 1024 / elapsed // KB/s
-			}
-
-			// 计算进度百分比
-			percent := float64(calDownloaded) / float64(totalSize) * 100
-			fmt.Printf("\rdownloaded: %.2f%% (%d/%d bytes, %.2f KB/s)", percent, calDownloaded, totalSize, speed)
-			if thisDownload == info.Response.ContentLength {
-				downloaded += thisDownload
-			}
-			// 相等即已经处理完毕
-			if downloaded == totalSize {
-				fmt.Println()
-			}
-		}
+	tempDir := req.TempDir
+	if tempDir == "" {
+		tempDir = req.LocalPath
 	}
-	tempDir := "./tempDir/" + object.Fid
 	err = os.MkdirAll(tempDir, os.ModePerm)
 	if err != nil {
 		return err
 	}
-	segmentSize := int64(250 * 1024 * 1024)
-	start := int64(0)
-	tempFiles := make([]string, 0)
-	for i := 0; ; i++ {
-		end := start + (segmentSize - 1)
-		if end > (totalSize - 1) {
-			end = totalSize - 1
-		}
-		tempFileName, err := handleTask(c.sessionClient, start, end, downloadUrl, tempDir, callback)
+	segmentSize := req.SegmentSize
+	if segmentSize <= 0 {
+		segmentSize = defaultSegmentSize
+	}
+
+	abs, _ := filepath.Abs(outputFile)
+	resumeKey := md5Hash(object.Fid + abs)
+	tempFile, _ := filepath.Abs(filepath.Join(tempDir, object.FileName+"."+resumeKey[:8]+".part"))
+	remoteMd5 := remote.Md5
+	journal := c.loadJournal(resumeKey, newDownloadJournal(object, remoteMd5, segmentSize, tempFile))
+	if len(journal.Segments) > 0 {
+		c.logger.Info("resume download", "fid", object.Fid, "path", outputFile, "segments", len(journal.Segments))
+		tracker.resume(journal.doneBytes())
+	}
+	verifyFailures := 0
+	for {
+		file, err := openTempFile(journal)
 		if err != nil {
 			return err
 		}
-		tempFiles = append(tempFiles, tempFileName)
-		if end < (totalSize - 1) {
-			start = end + 1
+		err = c.downloadSegments(ctx, file, downloadUrl, journal, max(req.Concurrency, 1), tracker, func() {
+			c.saveJournal(resumeKey, journal)
+		})
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if errors.Is(err, errRangeUnsupported) && journal.segmentCount() > 1 {
+			// 服务端不支持Range时改为单个分段从头下载
+			c.logger.Warn("range not supported, download as a single stream", "fid", object.Fid, "path", outputFile)
+			_ = c.delState(journalKey(resumeKey))
+			journal = newDownloadJournal(object, remoteMd5, max(totalSize, 1), tempFile)
+			tracker.resume(0)
 			continue
 		}
-		break
+		if err != nil {
+			return err
+		}
+		err = c.verifyDownload(ctx, tempFile, totalSize, remoteMd5)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrChecksumMismatch) {
+			return err
+		}
+		// 不一致的文件不能用于续传，删除或隔离后从头下载
+		_ = c.delState(journalKey(resumeKey))
+		quarantined, removeErr := removeLocal(tempFile, req.QuarantineDir)
+		verifyFailures++
+		c.logger.Warn("download verification failed", "fid", object.Fid, "path", outputFile, "attempt", verifyFailures, "err", err, "quarantine", quarantined)
+		if removeErr != nil {
+			return errors.Join(err, removeErr)
+		}
+		if verifyFailures > req.VerifyRetries {
+			return err
+		}
+		journal = newDownloadJournal(object, remoteMd5, segmentSize, tempFile)
+		tracker.resume(0)
 	}
-
-	err = mergeFile(outputFile, tempFiles)
-	if err != nil {
-		return err
+	if err = os.Rename(tempFile, outputFile); err != nil {
+		// 临时目录与下载目录不在同一磁盘时无法rename，复制后再删除
+		_ = os.Remove(outputFile)
+		if err = copyFile(tempFile, outputFile); err != nil {
+			return err
+		}
+		_ = os.Remove(tempFile)
 	}
+	_ = c.delState(journalKey(resumeKey))
 
-	fmt.Println("end download file", object.FileName)
-	if downloadCallback != nil {
-		abs, _ := filepath.Abs(outputFile)
-		downloadCallback(filepath.Dir(abs), abs)
+	tracker.emit(EventFileFinished, 0, nil)
+	c.logger.Info("end download file", "fid", object.Fid, "name", object.FileName, "path", outputFile)
+	if req.Callback != nil {
+		req.Callback(filepath.Dir(abs), abs)
 	}
 	return nil
 }
 
+// downloadSegments 并发下载未完成的分段并写入file的对应位置，每个分段完成后调用saved，调用之间不会并发
+func (c *QuarkClient) downloadSegments(ctx context.Context, file *os.File, url string, journal *downloadJournal, concurrency int, tracker *progressTracker, saved func()) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	segments := make(chan int)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range segments {
+				start, end := journal.segment(index)
+				err := c.downloadSegment(ctx, file, url, start, end, journal.Size, tracker)
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					mu.Unlock()
+					continue
+				}
+				journal.Segments[index] = true
+				saved()
+				mu.Unlock()
+				c.logger.Debug("downloaded segment", "path", tracker.path, "segment", index, "start", start, "end", end)
+				tracker.emit(EventPartCompleted, index+1, nil)
+			}
+		}()
+	}
+
+	// 已完成的分段只在开始前读取，之后只由持有锁的worker写入
+	pending := make([]int, 0, journal.segmentCount())
+	for index := 0; index < journal.segmentCount(); index++ {
+		if !journal.Segments[index] {
+			pending = append(pending, index)
+		}
+	}
+feed:
+	for _, index := range pending {
+		select {
+		case segments <- index:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(segments)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
 // ShareFile 一键创建分享
 func (c *QuarkClient) ShareFile(req ShareReq) (*RespData[SharePasswordData], error) {
-	shareId, err := c.Share(req)
+	return c.ShareFileCtx(context.Background(), req)
+}
+
+func (c *QuarkClient) ShareFileCtx(ctx context.Context, req ShareReq) (*RespData[SharePasswordData], error) {
+	shareId, err := c.ShareCtx(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	return c.SharePassword(shareId)
+	// 演练时分享并未创建，没有可查询的分享
+	if _, dryRun := c.dryRun(ctx); dryRun {
+		return &RespData[SharePasswordData]{}, nil
+	}
+	return c.SharePasswordCtx(ctx, shareId)
 }
 
 // FileId 此方法会判断目录是否存在，不存在会直接创建
 func (c *QuarkClient) FileId(path string, usingCache, autoCreate bool) (string, error) {
+	return c.FileIdCtx(context.Background(), path, usingCache, autoCreate)
+}
+
+func (c *QuarkClient) FileIdCtx(ctx context.Context, path string, usingCache, autoCreate bool) (string, error) {
+	if autoCreate {
+		c.dirMu.Lock()
+		defer c.dirMu.Unlock()
+	}
 	truePath := strings.Trim(path, "/")
 	paths := strings.Split(truePath, "/")
 
+	// 演练时计划创建的目录只记录在ctx的状态中
+	planned, _ := ctx.Value(dryRunKey{}).(*dryRunState)
 	fileId := "0"
 	lastParentId := "0"
 	var search []File
@@ -407,26 +1047,29 @@ func (c *QuarkClient) FileId(path string, usingCache, autoCreate bool) (string,
 			continue
 		}
 		if usingCache {
-			cacheSearch, found := dirCache.Get(lastParentId)
+			cacheSearch, found := c.dirCache.Get(lastParentId)
 			if found {
 				search = cacheSearch
 			} else {
-				remoteSearch, err := c.FileSort(lastParentId)
+				remoteSearch, err := c.FileSortCtx(ctx, lastParentId)
 				if err != nil {
 					return "", err
 				}
 				if len(remoteSearch) > 0 {
-					dirCache.Set(lastParentId, remoteSearch)
+					c.dirCache.Set(lastParentId, remoteSearch)
 				}
 				search = remoteSearch
 			}
 		} else {
-			remoteSearch, err := c.FileSort(lastParentId)
+			remoteSearch, err := c.FileSortCtx(ctx, lastParentId)
 			if err != nil {
 				return "", err
 			}
 			search = remoteSearch
 		}
+		if planned != nil {
+			search = append(search[:len(search):len(search)], planned.plannedDirs(lastParentId)...)
+		}
 
 		exist := false
 		for _, file := range search {
@@ -438,14 +1081,19 @@ func (c *QuarkClient) FileId(path string, usingCache, autoCreate bool) (string,
 		}
 		if !exist {
 			if filepath.Ext(pathStr) == "" && autoCreate {
-				dir, err := c.MakeDir(pathStr, lastParentId)
+				dir, err := c.MakeDirCtx(ctx, pathStr, lastParentId)
 				if err != nil {
 					return "", err
 				}
-				dirCache.Del(lastParentId)
+				if planned != nil {
+					// 演练时目录并未创建，记录计划创建的目录，避免同一目录被重复计划
+					planned.addDir(File{Fid: dir.Data.Fid, FileName: pathStr, PdirFid: lastParentId})
+				} else if !isDryRunFid(dir.Data.Fid) {
+					c.dirCache.Del(lastParentId)
+				}
 				lastParentId = dir.Data.Fid
 			} else {
-				return "", fmt.Errorf("file not found:%s", path)
+				return "", fmt.Errorf("%w:%s", ErrNotFound, path)
 			}
 		}
 		if index == len(paths)-1 {
@@ -455,49 +1103,52 @@ func (c *QuarkClient) FileId(path string, usingCache, autoCreate bool) (string,
 	return fileId, nil
 }
 
-func handleTask(client *req.Client, rangeStart, rangeEnd int64, url, tempDir string, downloadCallback req.DownloadCallback) (string, error) {
-	tempFilename := getRangeTempFile(rangeStart, rangeEnd, tempDir)
-
-	file, err := os.Create(tempFilename)
-	if err != nil {
-		return "", err
-	}
-
-	_, err = client.R().
-		SetHeader("Range", fmt.Sprintf("bytes=%d-%d", rangeStart, rangeEnd)).
-		SetOutput(file).
-		SetDownloadCallback(downloadCallback).Get(url)
-
-	if err != nil {
-		return "", err
-	}
-	return tempFilename, nil
-}
-
-func getRangeTempFile(rangeStart, rangeEnd int64, workerDir string) string {
-	return filepath.Join(workerDir, fmt.Sprintf("temp-%d-%d", rangeStart, rangeEnd))
-}
-
-func mergeFile(filename string, tempFiles []string) error {
-	outputFile, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer outputFile.Close()
-	for _, temp := range tempFiles {
-		tempFile, err := os.Open(temp)
+// downloadSegment 下载 [start, end] 范围的数据并写入file的对应位置，重试时从头写入该分段。
+// 只接受与请求范围一致的206响应，服务端忽略Range返回200时只有分段覆盖整个文件才接受，否则返回 errRangeUnsupported
+func (c *QuarkClient) downloadSegment(ctx context.Context, file *os.File, url string, start, end, size int64, tracker *progressTracker) error {
+	var written int64
+	return retryErr(ctx, c.opts.retry, func() error {
+		// 重试时扣除上次写入的进度
+		if written > 0 {
+			tracker.add(-written)
+			written = 0
+		}
+		res, err := c.sessionClient.R().
+			SetContext(ctx).
+			SetHeader("Range", fmt.Sprintf("bytes=%d-%d", start, end)).
+			DisableAutoReadResponse().
+			Get(url)
 		if err != nil {
 			return err
 		}
-		_, err = io.Copy(outputFile, tempFile)
-		tempFile.Close()
+		if !res.IsSuccessState() {
+			// 读取错误响应体用于解析OSS的错误码
+			_, _ = res.ToBytes()
+			return newOSSError(res)
+		}
+		defer res.Body.Close()
+		switch res.StatusCode {
+		case http.StatusPartialContent:
+			if err = checkContentRange(res.Header.Get("Content-Range"), start, end); err != nil {
+				return err
+			}
+		case http.StatusOK:
+			if start != 0 || end != size-1 {
+				return errRangeUnsupported
+			}
+		default:
+			return fmt.Errorf("segment %d-%d: unexpected status %d", start, end, res.StatusCode)
+		}
+		w := &segmentWriter{w: io.NewOffsetWriter(file, start), remaining: end - start + 1, tracker: tracker}
+		_, err = io.Copy(c.bandwidth.writer(ctx, w), res.Body)
+		written = end - start + 1 - w.remaining
 		if err != nil {
 			return err
 		}
-	}
-	// 两次循环是避免第一次循环出现错误
-	for _, temp := range tempFiles {
-		_ = os.Remove(temp)
-	}
-	return nil
+		// 响应体比请求的范围短时重试
+		if w.remaining > 0 {
+			return fmt.Errorf("segment %d-%d: %w", start, end, io.ErrUnexpectedEOF)
+		}
+		return nil
+	})
 }
@@ -0,0 +1,176 @@
+package quark
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+var (
+	defaultBaseURL = "https://drive.quark.cn/1/clouddrive"
+	defaultTimeout = 30 * time.Minute
+)
+
+// Option NewClient 的可选配置
+type Option func(*options)
+
+type options struct {
+	baseURL        string
+	ossHost        string
+	userAgent      string
+	timeout        time.Duration
+	requestTimeout time.Duration
+	proxy          string
+	transport      http.RoundTripper
+	tlsConfig      *tls.Config
+	queryParams    map[string]string
+	retry          RetryPolicy
+	rateLimits     map[EndpointClass]rateLimit
+	logger         *slog.Logger
+	stateStore     StateStore
+	account        string
+	dryRun         bool
+	dryRunRecord   func(op PlannedOperation)
+	bandwidth      int64
+	schedule       []BandwidthWindow
+}
+
+func defaultOptions() *options {
+	return &options{
+		baseURL:   defaultBaseURL,
+		userAgent: defaultUa,
+		timeout:   defaultTimeout,
+		queryParams: map[string]string{
+			"pr": "ucpro",
+			"fr": "pc",
+		},
+		retry:      DefaultRetryPolicy,
+		rateLimits: make(map[EndpointClass]rateLimit),
+		logger:     slog.New(discardHandler{}),
+	}
+}
+
+// WithBaseURL 设置网盘接口地址，默认 https://drive.quark.cn/1/clouddrive
+func WithBaseURL(baseURL string) Option {
+	return func(o *options) {
+		o.baseURL = baseURL
+	}
+}
+
+// WithOSSHost 覆盖分片上传的OSS地址，如 http://127.0.0.1:8080，设置后不再拼接bucket
+func WithOSSHost(host string) Option {
+	return func(o *options) {
+		o.ossHost = host
+	}
+}
+
+// WithUserAgent 设置请求的User-Agent
+func WithUserAgent(ua string) Option {
+	return func(o *options) {
+		o.userAgent = ua
+	}
+}
+
+// WithTimeout 设置单次请求的总超时（包含上传下载的数据传输），默认30分钟
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithRequestTimeout 设置每个请求等待响应头的超时，不影响数据传输的耗时
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.requestTimeout = timeout
+	}
+}
+
+// WithProxy 设置代理，如 http://127.0.0.1:7890 或 socks5://127.0.0.1:1080
+func WithProxy(proxyURL string) Option {
+	return func(o *options) {
+		o.proxy = proxyURL
+	}
+}
+
+// WithTransport 使用自定义的 http.RoundTripper 发送请求
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *options) {
+		o.transport = transport
+	}
+}
+
+// WithTLSConfig 设置TLS配置
+func WithTLSConfig(config *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = config
+	}
+}
+
+// WithQueryParam 设置网盘接口的公共查询参数，默认 pr=ucpro&fr=pc
+func WithQueryParam(key, value string) Option {
+	return func(o *options) {
+		o.queryParams[key] = value
+	}
+}
+
+// WithRetryPolicy 设置重试策略，默认 DefaultRetryPolicy，只对幂等请求生效
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = policy
+	}
+}
+
+// WithRateLimit 限制某类接口每秒的请求数，burst为允许的突发请求数，rate<=0 表示不限制
+func WithRateLimit(class EndpointClass, rate float64, burst int) Option {
+	return func(o *options) {
+		o.rateLimits[class] = rateLimit{rate: rate, burst: burst}
+	}
+}
+
+// WithBandwidthLimit 限制上传和下载共享的带宽（每秒字节数），<=0 表示不限制，可通过 SetBandwidthLimit 调整
+func WithBandwidthLimit(limit int64) Option {
+	return func(o *options) {
+		o.bandwidth = limit
+	}
+}
+
+// WithBandwidthSchedule 设置按时间段生效的带宽限制，见 SetBandwidthSchedule
+func WithBandwidthSchedule(windows ...BandwidthWindow) Option {
+	return func(o *options) {
+		o.schedule = windows
+	}
+}
+
+// WithLogger 设置日志输出，默认不输出任何日志
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		if logger != nil {
+			o.logger = logger
+		}
+	}
+}
+
+// WithStateStore 设置断点续传等状态的存储，默认保存在用户缓存目录下的 quark-client 中
+func WithStateStore(store StateStore) Option {
+	return func(o *options) {
+		o.stateStore = store
+	}
+}
+
+// WithAccount 设置状态存储中的账号前缀，默认由首次使用的 pus 计算，pus 刷新后会在状态存储中记录别名，
+// 以刷新后的 pus 重新创建客户端时沿用原来的前缀
+func WithAccount(account string) Option {
+	return func(o *options) {
+		o.account = account
+	}
+}
+
+// WithDryRun 开启演练模式，所有修改类操作（上传、创建目录、移动、删除等）只通过record报告而不发送请求，
+// 查询类请求照常发送。record可以为nil，此时只记录日志
+func WithDryRun(record func(op PlannedOperation)) Option {
+	return func(o *options) {
+		o.dryRun = true
+		o.dryRunRecord = record
+	}
+}
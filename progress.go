@@ -0,0 +1,197 @@
+package quark
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type ProgressEventType int
+
+const (
+	// EventFileStarted 开始上传或下载文件
+	EventFileStarted ProgressEventType = iota
+	// EventBytesTransferred 传输了新的数据
+	EventBytesTransferred
+	// EventPartCompleted 一个分片传输完成
+	EventPartCompleted
+	// EventFileFinished 文件传输完成
+	EventFileFinished
+	// EventFileFailed 文件传输失败，Err 为失败原因
+	EventFileFailed
+	// EventRapidUpload 秒传成功，没有传输数据
+	EventRapidUpload
+)
+
+func (t ProgressEventType) String() string {
+	switch t {
+	case EventFileStarted:
+		return "started"
+	case EventBytesTransferred:
+		return "transferred"
+	case EventPartCompleted:
+		return "part_completed"
+	case EventFileFinished:
+		return "finished"
+	case EventFileFailed:
+		return "failed"
+	case EventRapidUpload:
+		return "rapid_upload"
+	}
+	return "unknown"
+}
+
+// ProgressEvent 传输进度事件，速度单位均为 bytes/s
+type ProgressEvent struct {
+	Type ProgressEventType
+	// Path 本地文件路径，UploadReader 时为文件名
+	Path string
+	// Fid 网盘文件id，上传时在完成后才有值
+	Fid        string
+	PartNumber int
+	// Transferred 已传输的字节数，包含断点续传前已完成的部分
+	Transferred int64
+	Total       int64
+	// Rate 距上次事件的瞬时速度
+	Rate float64
+	// AvgRate 本次传输从开始到现在的平均速度
+	AvgRate float64
+	Elapsed time.Duration
+	Err     error
+}
+
+// ProgressListener 接收传输进度事件，实现需要自行保证并发安全
+type ProgressListener interface {
+	OnProgress(event ProgressEvent)
+}
+
+// ProgressFunc 函数形式的 ProgressListener
+type ProgressFunc func(event ProgressEvent)
+
+func (f ProgressFunc) OnProgress(event ProgressEvent) {
+	f(event)
+}
+
+// progressInterval 两次 EventBytesTransferred 之间的最小间隔
+var progressInterval = 500 * time.Millisecond
+
+// progressTracker 统计单个文件的传输进度并分发事件
+type progressTracker struct {
+	mu          sync.Mutex
+	listener    ProgressListener
+	logger      *slog.Logger
+	path        string
+	fid         string
+	total       int64
+	transferred int64
+	initial     int64
+	start       time.Time
+	lastTime    time.Time
+	lastBytes   int64
+}
+
+func newProgressTracker(listener ProgressListener, logger *slog.Logger, path, fid string, total, transferred int64) *progressTracker {
+	now := time.Now()
+	return &progressTracker{
+		listener:    listener,
+		logger:      logger,
+		path:        path,
+		fid:         fid,
+		total:       total,
+		transferred: transferred,
+		initial:     transferred,
+		start:       now,
+		lastTime:    now,
+		lastBytes:   transferred,
+	}
+}
+
+// add 记录新传输的字节数，n为负数时表示重传回退
+func (t *progressTracker) add(n int64) {
+	t.mu.Lock()
+	t.transferred += n
+	if time.Since(t.lastTime) < progressInterval && t.transferred != t.total {
+		t.mu.Unlock()
+		return
+	}
+	event := t.eventLocked(EventBytesTransferred, 0, nil)
+	t.mu.Unlock()
+	t.dispatch(event)
+}
+
+func (t *progressTracker) emit(typ ProgressEventType, partNumber int, err error) {
+	t.mu.Lock()
+	event := t.eventLocked(typ, partNumber, err)
+	t.mu.Unlock()
+	t.dispatch(event)
+}
+
+// resume 断点续传时设置已完成的字节数
+func (t *progressTracker) resume(transferred int64) {
+	t.mu.Lock()
+	t.transferred = transferred
+	t.initial = transferred
+	t.lastBytes = transferred
+	t.mu.Unlock()
+}
+
+// sent 本次实际传输的字节数，不含断点续传前已完成的部分
+func (t *progressTracker) sent() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.transferred - t.initial
+}
+
+func (t *progressTracker) setTotal(total int64) {
+	t.mu.Lock()
+	t.total = total
+	t.mu.Unlock()
+}
+
+func (t *progressTracker) setFid(fid string) {
+	t.mu.Lock()
+	t.fid = fid
+	t.mu.Unlock()
+}
+
+func (t *progressTracker) eventLocked(typ ProgressEventType, partNumber int, err error) ProgressEvent {
+	now := time.Now()
+	elapsed := now.Sub(t.start)
+	event := ProgressEvent{
+		Type:        typ,
+		Path:        t.path,
+		Fid:         t.fid,
+		PartNumber:  partNumber,
+		Transferred: t.transferred,
+		Total:       t.total,
+		Elapsed:     elapsed,
+		Err:         err,
+	}
+	if since := now.Sub(t.lastTime).Seconds(); since > 0 {
+		event.Rate = float64(t.transferred-t.lastBytes) / since
+	}
+	if elapsed > 0 {
+		event.AvgRate = float64(t.transferred-t.initial) / elapsed.Seconds()
+	}
+	if typ == EventBytesTransferred {
+		t.lastTime = now
+		t.lastBytes = t.transferred
+	}
+	return event
+}
+
+func (t *progressTracker) dispatch(event ProgressEvent) {
+	if t.logger != nil {
+		level := slog.LevelDebug
+		if event.Type == EventFileFailed {
+			level = slog.LevelWarn
+		}
+		t.logger.Log(context.Background(), level, "progress", "event", event.Type.String(), "path", event.Path,
+			"fid", event.Fid, "part", event.PartNumber, "transferred", event.Transferred,
+			"total", event.Total, "rate", event.Rate, "err", event.Err)
+	}
+	if t.listener != nil {
+		t.listener.OnProgress(event)
+	}
+}
@@ -1,9 +1,11 @@
 package quark
 
 import (
+	"github.com/Xhofe/go-cache"
 	"github.com/imroc/req/v3"
+	"log/slog"
 	"net/http"
-	"time"
+	"sync"
 )
 
 var defaultUa = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) quark-cloud-drive/2.5.20 Chrome/100.0.4896.160 Electron/18.3.5.4-b478491100 Safari/537.36 Channel/pckk_other_ch"
@@ -13,23 +15,52 @@ type SessionRefresh func(session string)
 type QuarkClient struct {
 	pus           string
 	puus          string
+	opts          *options
+	logger        *slog.Logger
 	sessionClient *req.Client
 	defaultClient *req.Client
-	pusRefresh    SessionRefresh
-	puusRefresh   SessionRefresh
+	bandwidth     *bandwidthLimiter
+	// dirCache 远程目录列表的缓存，key为目录fid
+	dirCache cache.ICache[[]File]
+	// dirMu 串行化远程目录的创建以及 dirCache 的读-改-写，避免并发上传时重复创建同名目录
+	dirMu       sync.Mutex
+	pusRefresh  SessionRefresh
+	puusRefresh SessionRefresh
 }
 
-func NewClient(pus, puus string) *QuarkClient {
+func NewClient(pus, puus string, opts ...Option) *QuarkClient {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.stateStore == nil {
+		o.stateStore = defaultStateStore()
+	}
+	if o.account == "" {
+		o.account = resolveAccount(o.stateStore, pus)
+	}
 	client := &QuarkClient{
 		pus:           pus,
 		puus:          puus,
-		sessionClient: initSessionClient(pus, puus),
-		defaultClient: initDefaultClient(),
+		opts:          o,
+		logger:        o.logger,
+		sessionClient: initSessionClient(pus, puus, o),
+		defaultClient: initDefaultClient(o),
+		bandwidth:     newBandwidthLimiter(o.bandwidth, o.schedule),
+		dirCache:      newDirCache(),
 	}
 	return client
 }
 
+// newDirCache 目录列表不设置过期时间，关闭定期清理，避免每个客户端常驻一个协程
+func newDirCache() cache.ICache[[]File] {
+	return cache.NewMemCache(cache.WithShards[[]File](100), cache.WithClearInterval[[]File](0))
+}
+
 func (c *QuarkClient) refreshPus(pus string) *req.Client {
+	if pus != c.pus {
+		c.rememberAccount(pus)
+	}
 	c.pus = pus
 	if c.pusRefresh != nil {
 		c.pusRefresh(pus)
@@ -45,21 +76,41 @@ func (c *QuarkClient) refreshPuus(puus string) *req.Client {
 	return c.sessionClient.SetCommonCookies(&http.Cookie{Name: "__puus", Value: puus})
 }
 
-func initSessionClient(pus, puus string) *req.Client {
+func initSessionClient(pus, puus string, o *options) *req.Client {
 	sessionClient := req.C().
 		SetCommonHeaders(map[string]string{
-			"User-Agent": defaultUa,
+			"User-Agent": o.userAgent,
 			"Accept":     "application/json, text/plain, */*",
 			"Referer":    "https://pan.quark.cn",
 		}).
-		SetCommonQueryParam("pr", "ucpro").
-		SetCommonQueryParam("fr", "pc").
+		SetCommonQueryParams(o.queryParams).
 		SetCommonCookies(&http.Cookie{Name: "__pus", Value: pus}, &http.Cookie{Name: "__puus", Value: puus}).
-		SetTimeout(30 * time.Minute).SetBaseURL("https://drive.quark.cn/1/clouddrive")
-	return sessionClient
+		SetBaseURL(o.baseURL).
+		OnBeforeRequest(newRequestLimiter(o.rateLimits).onBeforeRequest)
+	return applyOptions(sessionClient, o)
 }
 
-func initDefaultClient() *req.Client {
-	sessionClient := req.C().SetTimeout(30 * time.Minute)
-	return sessionClient
+func initDefaultClient(o *options) *req.Client {
+	return applyOptions(req.C(), o)
+}
+
+// applyOptions 将传输相关的配置应用到client
+func applyOptions(client *req.Client, o *options) *req.Client {
+	client.SetTimeout(o.timeout)
+	if o.requestTimeout > 0 {
+		client.GetTransport().SetResponseHeaderTimeout(o.requestTimeout)
+	}
+	if o.proxy != "" {
+		client.SetProxyURL(o.proxy)
+	}
+	if o.tlsConfig != nil {
+		client.SetTLSClientConfig(o.tlsConfig)
+	}
+	if o.transport != nil {
+		transport := o.transport
+		client.GetTransport().WrapRoundTrip(func(http.RoundTripper) http.RoundTripper {
+			return transport
+		})
+	}
+	return client
 }
@@ -0,0 +1,1598 @@
+package quark
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewClientWithOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("User-Agent") != "test-agent" || r.URL.Query().Get("pr") != "ucpro" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":200,"code":0,"message":"ok","data":{"share_enable":1}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(pus, puus, WithBaseURL(server.URL), WithUserAgent("test-agent"))
+	resp, err := client.Config()
+	if err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+	if resp.Data.ShareEnable != 1 {
+		t.Fatalf("unexpected config: %+v", resp.Data)
+	}
+}
+
+func TestConfigCtxCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := NewClient(pus, puus, WithBaseURL(server.URL))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := client.ConfigCtx(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got: %v", err)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"status":401,"code":31001,"message":"require login [guest]","metadata":{"req_id":"abc"}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(pus, puus, WithBaseURL(server.URL))
+	_, err := client.FileSort("0")
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected unauthorized, got: %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != 31001 || apiErr.ReqId != "abc" || apiErr.Endpoint != "/file/sort" {
+		t.Fatalf("unexpected api error: %+v", apiErr)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if call < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"status":503,"code":0,"message":"busy"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":200,"code":0,"message":"ok","data":{"share_enable":1}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(pus, puus, WithBaseURL(server.URL), WithRetryPolicy(RetryPolicy{
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  10 * time.Millisecond,
+		RetryStatus: []int{http.StatusServiceUnavailable},
+	}))
+	_, err := client.Config()
+	if err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 calls, got: %d", calls.Load())
+	}
+}
+
+func TestCommitNotRetried(t *testing.T) {
+	drive := newFakeDrive(4)
+	defer drive.Close()
+	drive.failCommit = true
+	client := drive.client(WithRetryPolicy(RetryPolicy{MaxAttempts: 3, RetryStatus: []int{http.StatusServiceUnavailable}}))
+	_, err := client.UploadReader(context.Background(), strings.NewReader("0123456789"), 10, "/", "commit.txt", UploadReaderOpts{})
+	if err == nil {
+		t.Fatal("expected commit error")
+	}
+	if drive.commits != 1 {
+		t.Fatalf("expected 1 commit, got: %d", drive.commits)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":200,"code":0,"message":"ok","data":{}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(pus, puus, WithBaseURL(server.URL), WithRateLimit(ClassList, 10, 1))
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := client.Config(); err != nil {
+			fmt.Println(err)
+			panic(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("rate limit not applied, elapsed: %s", elapsed)
+	}
+}
+
+// fakeDrive 模拟网盘接口及OSS的测试服务
+type fakeDrive struct {
+	*httptest.Server
+	mu       sync.Mutex
+	partSize int
+	rapid    bool
+	// rapidNames 这些文件名秒传成功
+	rapidNames map[string]bool
+	parts      map[int][]byte
+	commit     string
+	// commits 合并请求的次数，failCommit 时合并返回503
+	commits    int
+	failCommit bool
+	files      []File
+	preName    string
+	preBody    map[string]any
+	pres       int
+	// failPart 该分片返回不可重试的错误，staleUpload 下一个分片返回 NoSuchUpload
+	failPart    int
+	staleUpload bool
+	// retryPart 该分片第一次返回503
+	retryPart int
+	aborted   []string
+	// uploads 每次pre的"父目录fid/文件名"，新建目录的fid为目录名
+	uploads []string
+	// dirs 新建的目录，key为父目录fid
+	dirs map[string][]File
+	// deleted renamed 删除的fid和"fid/新文件名"
+	deleted []string
+	renamed []string
+	// badMd5 下载接口返回错误的MD5
+	badMd5 bool
+	// download 不为空时作为下载的文件内容，ranges 记录每次请求的Range，failRange 该Range返回错误
+	download  []byte
+	ranges    []string
+	failRange string
+	// shortRange 该Range下一次只返回一半的数据，ignoreRange 忽略Range返回整个文件
+	shortRange  string
+	ignoreRange bool
+}
+
+func newFakeDrive(partSize int) *fakeDrive {
+	d := &fakeDrive{partSize: partSize, parts: make(map[int][]byte), dirs: make(map[string][]File)}
+	d.Server = httptest.NewServer(http.HandlerFunc(d.handle))
+	return d
+}
+
+func (d *fakeDrive) client(opts ...Option) *QuarkClient {
+	return NewClient(pus, puus, append([]Option{WithBaseURL(d.URL), WithOSSHost(d.URL + "/oss"), WithStateStore(NewMemoryStore())}, opts...)...)
+}
+
+func (d *fakeDrive) handle(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	writeJson := func(data string) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":200,"code":0,"message":"ok",` + data + `}`))
+	}
+	switch {
+	case r.URL.Path == "/file/sort":
+		files := append(d.files[:len(d.files):len(d.files)], d.dirs[r.URL.Query().Get("pdir_fid")]...)
+		list, _ := json.Marshal(files)
+		writeJson(fmt.Sprintf(`"data":{"list":%s},"metadata":{"_total":%d}`, list, len(files)))
+	case r.URL.Path == "/file/upload/pre":
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		d.preName, _ = body["file_name"].(string)
+		d.preBody = body
+		d.pres++
+		d.uploads = append(d.uploads, fmt.Sprintf("%v/%v", body["pdir_fid"], body["file_name"]))
+		writeJson(fmt.Sprintf(`"data":{"task_id":"task","upload_id":"upload","obj_key":"obj","fid":"fid","bucket":"bucket","upload_url":"http://oss"},"metadata":{"part_size":%d,"part_thread":3}`, d.partSize))
+	case r.URL.Path == "/file" && r.Method == http.MethodPost:
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		pdir, name := fmt.Sprint(body["pdir_fid"]), fmt.Sprint(body["file_name"])
+		d.dirs[pdir] = append(d.dirs[pdir], File{Fid: name, FileName: name, PdirFid: pdir})
+		writeJson(fmt.Sprintf(`"data":{"finish":true,"fid":"%s"}`, name))
+	case r.URL.Path == "/file/delete":
+		var body struct {
+			FileList []string `json:"filelist"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		d.deleted = append(d.deleted, body.FileList...)
+		writeJson(`"data":{"finish":true}`)
+	case r.URL.Path == "/file/rename":
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		d.renamed = append(d.renamed, fmt.Sprintf("%v/%v", body["fid"], body["file_name"]))
+		writeJson(`"data":{"finish":true}`)
+	case r.URL.Path == "/file/update/hash":
+		writeJson(fmt.Sprintf(`"data":{"finish":%t,"fid":"fid"}`, d.rapid || d.rapidNames[d.preName]))
+	case r.URL.Path == "/file/download":
+		var content []byte
+		for i := 1; i <= len(d.parts); i++ {
+			content = append(content, d.parts[i]...)
+		}
+		if d.download != nil {
+			content = d.download
+		}
+		sum := md5Hash(string(content))
+		if d.badMd5 {
+			sum = md5Hash("bad")
+		}
+		writeJson(fmt.Sprintf(`"data":[{"fid":"fid","size":%d,"md5":"%s","download_url":"%s/dl"}]`, len(content), sum, d.URL))
+	case r.URL.Path == "/dl":
+		rangeHeader := r.Header.Get("Range")
+		d.ranges = append(d.ranges, rangeHeader)
+		if rangeHeader == d.failRange {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("<Error><Code>AccessDenied</Code><Message>expired</Message></Error>"))
+			return
+		}
+		if d.ignoreRange {
+			_, _ = w.Write(d.download)
+			return
+		}
+		var start, end int
+		_, _ = fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(d.download)))
+		if rangeHeader == d.shortRange {
+			d.shortRange = ""
+			end = (start + end) / 2
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(d.download[start : end+1])
+	case r.URL.Path == "/file/upload/auth", r.URL.Path == "/file/upload/finish":
+		writeJson(`"data":{"auth_key":"key"}`)
+	case r.URL.Path == "/oss/obj" && r.Method == http.MethodPut:
+		part, _ := strconv.Atoi(r.URL.Query().Get("partNumber"))
+		body, _ := io.ReadAll(r.Body)
+		if d.staleUpload {
+			d.staleUpload = false
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("<Error><Code>NoSuchUpload</Code></Error>"))
+			return
+		}
+		if part == d.retryPart {
+			d.retryPart = 0
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if part == d.failPart {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("<Error><Code>InvalidArgument</Code></Error>"))
+			return
+		}
+		d.parts[part] = body
+		w.Header().Set("ETag", fmt.Sprintf("etag-%d", part))
+	case r.URL.Path == "/oss/obj" && r.Method == http.MethodDelete:
+		d.aborted = append(d.aborted, r.URL.Query().Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path == "/oss/obj" && r.Method == http.MethodPost:
+		d.commits++
+		if d.failCommit {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		d.commit = string(body)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestUploadFileParallel(t *testing.T) {
+	drive := newFakeDrive(4)
+	defer drive.Close()
+
+	localFile := filepath.Join(t.TempDir(), "data.txt")
+	content := []byte("0123456789abcdefghij!")
+	if err := os.WriteFile(localFile, content, 0644); err != nil {
+		panic(err)
+	}
+	var mu sync.Mutex
+	var last ProgressEvent
+	err := drive.client().UploadFile(OneStepUploadFileReq{
+		LocalFile:  localFile,
+		RemotePath: "/",
+		Progress: ProgressFunc(func(event ProgressEvent) {
+			mu.Lock()
+			last = event
+			mu.Unlock()
+		}),
+	})
+	if err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+	var uploaded []byte
+	for i := 1; i <= len(drive.parts); i++ {
+		uploaded = append(uploaded, drive.parts[i]...)
+	}
+	if string(uploaded) != string(content) {
+		t.Fatalf("unexpected content: %s", uploaded)
+	}
+	for i := 1; i <= 6; i++ {
+		if !strings.Contains(drive.commit, fmt.Sprintf("<PartNumber>%d</PartNumber>\n<ETag>etag-%d</ETag>", i, i)) {
+			t.Fatalf("part %d missing in commit: %s", i, drive.commit)
+		}
+	}
+	if last.Type != EventFileFinished || last.Transferred != int64(len(content)) {
+		t.Fatalf("unexpected last event: %+v", last)
+	}
+}
+
+func TestGetFileHash(t *testing.T) {
+	localFile := filepath.Join(t.TempDir(), "hash.txt")
+	if err := os.WriteFile(localFile, []byte("quark"), 0644); err != nil {
+		panic(err)
+	}
+	md5Str, sha1Str, err := getFileHash(context.Background(), localFile)
+	if err != nil {
+		panic(err)
+	}
+	if md5Str != md5Hash("quark") {
+		t.Fatalf("unexpected md5: %s", md5Str)
+	}
+	if sha1Str != "b58c9904ba6f4a89d1b64ca1214dfbdfb0cde76d" {
+		t.Fatalf("unexpected sha1: %s", sha1Str)
+	}
+}
+
+func TestHashCache(t *testing.T) {
+	localFile := filepath.Join(t.TempDir(), "hash.txt")
+	store := NewMemoryStore()
+	client := NewClient(pus, puus, WithStateStore(store))
+	for i, content := range []string{"quark", "quark", "changed"} {
+		if err := os.WriteFile(localFile, []byte(content), 0644); err != nil {
+			panic(err)
+		}
+		mtime := time.Unix(int64(1000+len(content)), 0)
+		if err := os.Chtimes(localFile, mtime, mtime); err != nil {
+			panic(err)
+		}
+		info, err := os.Stat(localFile)
+		if err != nil {
+			panic(err)
+		}
+		hash, err := client.fileHash(context.Background(), localFile, info, true)
+		if err != nil {
+			panic(err)
+		}
+		if hash.Md5 != md5Hash(content) {
+			t.Fatalf("%d: unexpected md5 %s", i, hash.Md5)
+		}
+	}
+	// 修改后的文件覆盖原有的缓存，不会累积
+	keys, err := store.List("")
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("expected 1 cache entry, got: %v %v", keys, err)
+	}
+}
+
+func TestUploadReader(t *testing.T) {
+	drive := newFakeDrive(4)
+	defer drive.Close()
+
+	content := "stream content for spool"
+	// 只暴露 io.Reader，强制走临时文件缓存
+	reader := struct{ io.Reader }{strings.NewReader(content)}
+	fid, err := drive.client().UploadReader(context.Background(), reader, int64(len(content)), "/", "stream.txt", UploadReaderOpts{
+		SpoolMemory: 8,
+		SpoolDir:    t.TempDir(),
+	})
+	if err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+	var uploaded []byte
+	for i := 1; i <= len(drive.parts); i++ {
+		uploaded = append(uploaded, drive.parts[i]...)
+	}
+	if fid != "fid" || string(uploaded) != content {
+		t.Fatalf("unexpected upload: %s %s", fid, uploaded)
+	}
+}
+
+func TestUploadReaderUnknownSize(t *testing.T) {
+	drive := newFakeDrive(4)
+	defer drive.Close()
+
+	content := "stream of unknown size"
+	for _, reader := range []io.Reader{struct{ io.Reader }{strings.NewReader(content)}, strings.NewReader(content)} {
+		_, err := drive.client().UploadReader(context.Background(), reader, -1, "/", "unknown.txt", UploadReaderOpts{SpoolDir: t.TempDir()})
+		if err != nil {
+			panic(err)
+		}
+		if size := drive.preBody["size"]; size != float64(len(content)) {
+			t.Fatalf("unexpected size: %v", size)
+		}
+	}
+}
+
+func TestUploadFileConflict(t *testing.T) {
+	drive := newFakeDrive(4)
+	defer drive.Close()
+	drive.files = []File{{Fid: "exist", FileName: "data.txt", Size: 5, File: true}}
+
+	localFile := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(localFile, []byte("12345"), 0644); err != nil {
+		panic(err)
+	}
+	client := drive.client()
+
+	client.dirCache.Clear()
+	err := client.UploadFile(OneStepUploadFileReq{LocalFile: localFile, RemotePath: "/", ConflictPolicy: ConflictSkip})
+	if err != nil || drive.preName != "" {
+		t.Fatalf("expected skip, got: %v %s", err, drive.preName)
+	}
+
+	client.dirCache.Clear()
+	err = client.UploadFile(OneStepUploadFileReq{LocalFile: localFile, RemotePath: "/", ConflictPolicy: ConflictFail})
+	if !errors.Is(err, ErrNameConflict) {
+		t.Fatalf("expected name conflict, got: %v", err)
+	}
+
+	client.dirCache.Clear()
+	err = client.UploadFile(OneStepUploadFileReq{LocalFile: localFile, RemotePath: "/", ConflictPolicy: ConflictRename})
+	if err != nil || drive.preName != "data (1).txt" {
+		t.Fatalf("expected rename, got: %v %s", err, drive.preName)
+	}
+
+	// 大小相同但MD5不同时不跳过
+	client.dirCache.Clear()
+	drive.preName = ""
+	drive.files[0].Md5 = md5Hash("54321")
+	err = client.UploadFile(OneStepUploadFileReq{LocalFile: localFile, RemotePath: "/", ConflictPolicy: ConflictSkip})
+	if err != nil || drive.preName != "data.txt" {
+		t.Fatalf("expected upload on md5 mismatch, got: %v %s", err, drive.preName)
+	}
+
+	// 覆盖时上传失败保留原文件
+	client.dirCache.Clear()
+	drive.failCommit = true
+	err = client.UploadFile(OneStepUploadFileReq{LocalFile: localFile, RemotePath: "/", ConflictPolicy: ConflictOverwrite})
+	if err == nil || len(drive.deleted) != 0 || !strings.HasPrefix(drive.preName, "data.uploading-") {
+		t.Fatalf("expected old file kept, got: %v %v %s", err, drive.deleted, drive.preName)
+	}
+	client.dirCache.Clear()
+	drive.failCommit = false
+	err = client.UploadFile(OneStepUploadFileReq{LocalFile: localFile, RemotePath: "/", ConflictPolicy: ConflictOverwrite})
+	if err != nil || strings.Join(drive.deleted, ",") != "exist" || strings.Join(drive.renamed, ",") != "fid/data.txt" {
+		t.Fatalf("expected overwrite, got: %v %v %v", err, drive.deleted, drive.renamed)
+	}
+}
+
+func TestDirCachePerClient(t *testing.T) {
+	driveA, driveB := newFakeDrive(4), newFakeDrive(4)
+	defer driveA.Close()
+	defer driveB.Close()
+	driveA.files = []File{{Fid: "a-dir", FileName: "dir"}}
+	driveB.files = []File{{Fid: "b-dir", FileName: "dir"}}
+
+	// 不同账号的根目录列表互不影响
+	for _, tc := range []struct {
+		drive *fakeDrive
+		fid   string
+	}{{driveA, "a-dir"}, {driveB, "b-dir"}} {
+		fid, err := tc.drive.client().FileId("/dir", true, false)
+		if err != nil || fid != tc.fid {
+			t.Fatalf("expected %s, got: %s %v", tc.fid, fid, err)
+		}
+	}
+}
+
+func TestTryRapidUpload(t *testing.T) {
+	drive := newFakeDrive(4)
+	defer drive.Close()
+
+	localFile := filepath.Join(t.TempDir(), "rapid.txt")
+	if err := os.WriteFile(localFile, []byte("rapid"), 0644); err != nil {
+		panic(err)
+	}
+	client := drive.client()
+	result, err := client.TryRapidUpload(localFile, "/")
+	if err != nil {
+		panic(err)
+	}
+	if result.Hit || len(drive.parts) != 0 || strings.Join(drive.aborted, ",") != "upload" {
+		t.Fatalf("expected miss without transfer and aborted pre, got: %+v %v", result, drive.aborted)
+	}
+
+	// 目标目录不存在时不创建目录
+	client.dirCache.Clear()
+	pres := drive.pres
+	result, err = client.TryRapidUpload(localFile, "/missing/sub")
+	if err != nil {
+		panic(err)
+	}
+	if result.Hit || len(drive.dirs) != 0 || drive.pres != pres {
+		t.Fatalf("expected miss without creating dirs, got: %+v %v", result, drive.dirs)
+	}
+
+	drive.rapid = true
+	result, err = client.TryRapidUpload(localFile, "/")
+	if err != nil {
+		panic(err)
+	}
+	if !result.Hit || result.Fid != "fid" {
+		t.Fatalf("expected hit, got: %+v", result)
+	}
+}
+
+func TestStateStore(t *testing.T) {
+	dir := t.TempDir()
+	fileStore, err := NewFileStore(filepath.Join(dir, "state.db"))
+	if err != nil {
+		panic(err)
+	}
+	stores := map[string]StateStore{
+		"memory": NewMemoryStore(),
+		"diskv":  NewDiskvStore(filepath.Join(dir, "diskv")),
+		"file":   fileStore,
+	}
+	for name, store := range stores {
+		if err = store.Set("a_session_1", []byte("s1"), 0); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err = store.Set("a_session_2", []byte("s2"), time.Nanosecond); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err = store.Set("b_session_1", []byte("s3"), time.Hour); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		time.Sleep(time.Millisecond)
+		value, ok, err := store.Get("a_session_1")
+		if err != nil || !ok || string(value) != "s1" {
+			t.Fatalf("%s: unexpected get: %q %v %v", name, value, ok, err)
+		}
+		if _, ok, _ = store.Get("a_session_2"); ok {
+			t.Fatalf("%s: expired key returned", name)
+		}
+		keys, err := store.List("a_")
+		if err != nil || len(keys) != 1 || keys[0] != "a_session_1" {
+			t.Fatalf("%s: unexpected list: %v %v", name, keys, err)
+		}
+		if err = store.Delete("a_session_1"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err = store.Delete("a_session_1"); err != nil {
+			t.Fatalf("%s: delete missing key: %v", name, err)
+		}
+		if _, ok, _ = store.Get("a_session_1"); ok {
+			t.Fatalf("%s: deleted key returned", name)
+		}
+	}
+
+	// 其他进程持有文件锁时打开失败，不会覆盖其状态
+	timeout := fileStoreLockTimeout
+	fileStoreLockTimeout = 50 * time.Millisecond
+	defer func() { fileStoreLockTimeout = timeout }()
+	if _, err = NewFileStore(filepath.Join(dir, "state.db")); err == nil {
+		t.Fatal("expected lock timeout")
+	}
+	if err = fileStore.(io.Closer).Close(); err != nil {
+		panic(err)
+	}
+	reopened, err := NewFileStore(filepath.Join(dir, "state.db"))
+	if err != nil {
+		panic(err)
+	}
+	defer reopened.(io.Closer).Close()
+	if value, ok, _ := reopened.Get("b_session_1"); !ok || string(value) != "s3" {
+		t.Fatalf("file store not persisted: %q", value)
+	}
+
+	a := NewClient("pus-a", puus, WithStateStore(stores["memory"]))
+	b := NewClient("pus-b", puus, WithStateStore(stores["memory"]))
+	if err = a.setState("chunk_x", int64(10), 0); err != nil {
+		panic(err)
+	}
+	var chunk int64
+	if ok, _ := b.getState("chunk_x", &chunk); ok {
+		t.Fatal("state shared between accounts")
+	}
+	if ok, _ := a.getState("chunk_x", &chunk); !ok || chunk != 10 {
+		t.Fatalf("unexpected chunk: %d", chunk)
+	}
+
+	// pus刷新后以新pus重新创建的客户端仍属于同一账号
+	a.refreshPus("pus-a2")
+	a.refreshPus("pus-a3")
+	refreshed := NewClient("pus-a3", puus, WithStateStore(stores["memory"]))
+	if ok, _ := refreshed.getState("chunk_x", &chunk); !ok || chunk != 10 {
+		t.Fatalf("state lost after pus refresh: %d", chunk)
+	}
+}
+
+func TestUploadFileResume(t *testing.T) {
+	drive := newFakeDrive(4)
+	defer drive.Close()
+
+	localFile := filepath.Join(t.TempDir(), "resume.txt")
+	content := []byte("0123456789abcdefghij!")
+	if err := os.WriteFile(localFile, content, 0644); err != nil {
+		panic(err)
+	}
+	client := drive.client(WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	upload := func() error {
+		return client.UploadFile(OneStepUploadFileReq{
+			LocalFile:       localFile,
+			RemotePath:      "/",
+			Resumable:       true,
+			PartConcurrency: 1,
+		})
+	}
+	interrupt := func() {
+		drive.failPart = 3
+		if err := upload(); err == nil {
+			t.Fatal("expected interrupted upload")
+		}
+		drive.failPart = 0
+		drive.parts = make(map[int][]byte)
+	}
+
+	interrupt()
+	if err := upload(); err != nil {
+		panic(err)
+	}
+	if drive.pres != 1 {
+		t.Fatalf("expected resumed session, pre called %d times", drive.pres)
+	}
+	if _, ok := drive.parts[1]; ok || len(drive.parts) != 4 {
+		t.Fatalf("completed parts uploaded again: %d", len(drive.parts))
+	}
+	if !strings.Contains(drive.commit, "<ETag>etag-1</ETag>") {
+		t.Fatalf("resumed etag missing in commit: %s", drive.commit)
+	}
+
+	// 文件修改后重新开始
+	interrupt()
+	if err := os.Chtimes(localFile, time.Now(), time.Now().Add(time.Hour)); err != nil {
+		panic(err)
+	}
+	if err := upload(); err != nil {
+		panic(err)
+	}
+	if drive.pres != 3 || len(drive.parts) != 6 {
+		t.Fatalf("expected fresh session, pre: %d, parts: %d", drive.pres, len(drive.parts))
+	}
+
+	// 服务端会话失效后重新开始
+	interrupt()
+	drive.staleUpload = true
+	if err := upload(); err != nil {
+		panic(err)
+	}
+	if drive.pres != 5 || len(drive.parts) != 6 {
+		t.Fatalf("expected fresh session, pre: %d, parts: %d", drive.pres, len(drive.parts))
+	}
+
+	// 其他404不视为会话失效
+	if staleSession(&APIError{Status: http.StatusNotFound, OSSCode: "NoSuchKey", kind: ErrNotFound}) {
+		t.Fatal("NoSuchKey treated as stale session")
+	}
+}
+
+func TestPendingUploads(t *testing.T) {
+	drive := newFakeDrive(4)
+	defer drive.Close()
+
+	dir := t.TempDir()
+	client := drive.client(WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	drive.failPart = 3
+	for _, name := range []string{"a.txt", "b.txt"} {
+		localFile := filepath.Join(dir, name)
+		if err := os.WriteFile(localFile, []byte("0123456789abcdefghij!"), 0644); err != nil {
+			panic(err)
+		}
+		err := client.UploadFile(OneStepUploadFileReq{
+			LocalFile:       localFile,
+			RemotePath:      "/",
+			Resumable:       true,
+			PartConcurrency: 1,
+		})
+		if err == nil {
+			t.Fatal("expected interrupted upload")
+		}
+	}
+
+	pending, err := client.ListPendingUploads()
+	if err != nil {
+		panic(err)
+	}
+	if len(pending) != 2 || pending[0].BytesDone != 8 || pending[0].Size != 21 || pending[0].RemotePath != "/" {
+		t.Fatalf("unexpected pending uploads: %+v", pending)
+	}
+	other := NewClient("other", puus, WithStateStore(client.opts.stateStore))
+	if list, _ := other.ListPendingUploads(); len(list) != 0 {
+		t.Fatalf("pending uploads leaked to other account: %+v", list)
+	}
+
+	if err = client.AbortUpload(pending[0].Id); err != nil {
+		panic(err)
+	}
+	if len(drive.aborted) != 1 || drive.aborted[0] != "upload" {
+		t.Fatalf("multipart upload not aborted: %v", drive.aborted)
+	}
+	if err = client.AbortUpload(pending[0].Id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+
+	purged, err := client.PurgeStaleUploads(time.Hour)
+	if err != nil || len(purged) != 0 {
+		t.Fatalf("purged fresh upload: %+v %v", purged, err)
+	}
+	purged, err = client.PurgeStaleUploads(0)
+	if err != nil || len(purged) != 1 || purged[0].Id != pending[1].Id {
+		t.Fatalf("unexpected purge: %+v %v", purged, err)
+	}
+	if list, _ := client.ListPendingUploads(); len(list) != 0 {
+		t.Fatalf("pending uploads left: %+v", list)
+	}
+}
+
+func TestUploadPathFilter(t *testing.T) {
+	drive := newFakeDrive(1024)
+	defer drive.Close()
+
+	root := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+	files := map[string]string{
+		".quarkignore":          "*.log\n!keep.log\nbuild/\n/top.txt\n",
+		"top.txt":               "anchored ignore",
+		"a.txt":                 "a",
+		"debug.log":             "ignored by quarkignore",
+		"keep.log":              "negated",
+		"build/out.txt":         "ignored dir",
+		"docs/build":            "file named build is kept",
+		"docs/top.txt":          "anchored rule does not apply",
+		"docs/.quarkignore":     "!*.log\n",
+		"docs/notes.log":        "re-included by child quarkignore",
+		"node_modules/x/y.txt":  "excluded by glob",
+		"media/clip.tmp":        "ignored extension",
+		"media/big.bin":         strings.Repeat("0123456789", 5),
+		"media/old.txt":         "too old",
+		"media/skip-regexp.txt": "excluded by regexp",
+	}
+	for name, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			panic(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			panic(err)
+		}
+	}
+	if err := os.Chtimes(filepath.Join(root, "media", "old.txt"), old, old); err != nil {
+		panic(err)
+	}
+
+	report, err := drive.client().UploadPath(OneStepUploadPathReq{
+		LocalPath:        root,
+		RemotePath:       "/",
+		IgnoreExtensions: []string{".tmp"},
+		// 不在 Extensions 中的文件即使未被忽略也跳过，在其中的文件不会覆盖前面的忽略
+		Extensions:     []string{".txt", ".log", ".bin", "build", ".quarkignore"},
+		ExcludeGlobs:   []string{"node_modules/**"},
+		ExcludeRegexps: []string{`^media/skip-`},
+		MaxSize:        40,
+		ModifiedAfter:  time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		panic(err)
+	}
+	sort.Strings(drive.uploads)
+	expected := []string{"0/.quarkignore", "0/a.txt", "0/keep.log", "docs/.quarkignore", "docs/build", "docs/notes.log", "docs/top.txt"}
+	if strings.Join(drive.uploads, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected uploads: %v", drive.uploads)
+	}
+	if report.Counts[OutcomeUploaded] != len(expected) || report.Counts[OutcomeSkippedByFilter] != len(files)-len(expected) {
+		t.Fatalf("unexpected report counts: %v", report.Counts)
+	}
+
+	// 无法读取的忽略文件记录为失败并跳过所在目录，其他目录照常上传
+	root = t.TempDir()
+	for _, name := range []string{"bad/.quarkignore/x", "bad/a.txt", "ok/b.txt"} {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			panic(err)
+		}
+		if err = os.WriteFile(full, []byte(name), 0644); err != nil {
+			panic(err)
+		}
+	}
+	drive.uploads = nil
+	report, err = drive.client().UploadPath(OneStepUploadPathReq{LocalPath: root, RemotePath: "/"})
+	var pathErr *UploadPathError
+	if !errors.As(err, &pathErr) || len(pathErr.Files) != 1 || pathErr.Files[0].Path != filepath.Join(root, "bad", ".quarkignore") {
+		t.Fatalf("expected ignore file error, got: %v", err)
+	}
+	if strings.Join(drive.uploads, ",") != "ok/b.txt" || report.Counts[OutcomeFailed] != 1 {
+		t.Fatalf("unexpected uploads: %v, counts: %v", drive.uploads, report.Counts)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"**/*.mp4", "a.mp4", true},
+		{"**/*.mp4", "a/b/c.mp4", true},
+		{"a/**/c", "a/c", true},
+		{"a/**/c", "a/b/d/c", true},
+		{"a/**", "a", false},
+		{"a/**", "a/b/c", true},
+		{"*.txt", "a/b.txt", false},
+	}
+	for _, tc := range cases {
+		if matchGlob(tc.pattern, tc.name) != tc.match {
+			t.Errorf("matchGlob(%q, %q) != %t", tc.pattern, tc.name, tc.match)
+		}
+	}
+}
+
+func TestUploadPathConcurrency(t *testing.T) {
+	drive := newFakeDrive(1024)
+	defer drive.Close()
+
+	root := t.TempDir()
+	for i := 0; i < 20; i++ {
+		full := filepath.Join(root, fmt.Sprintf("dir%d", i%3), "sub", fmt.Sprintf("f%d.txt", i))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			panic(err)
+		}
+		if err := os.WriteFile(full, []byte(strconv.Itoa(i)), 0644); err != nil {
+			panic(err)
+		}
+	}
+	client := drive.client(WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	_, err := client.UploadPath(OneStepUploadPathReq{LocalPath: root, RemotePath: "/", Concurrency: 5})
+	if err != nil {
+		panic(err)
+	}
+	if len(drive.uploads) != 20 {
+		t.Fatalf("expected 20 uploads, got: %d", len(drive.uploads))
+	}
+	for pdir, dirs := range drive.dirs {
+		if pdir == "0" && len(dirs) != 3 || pdir != "0" && len(dirs) != 1 {
+			t.Fatalf("directory created more than once under %s: %+v", pdir, dirs)
+		}
+	}
+
+	drive.failPart = 1
+	drive.uploads = nil
+	report, err := client.UploadPath(OneStepUploadPathReq{LocalPath: root, RemotePath: "/", Concurrency: 5, SkipFileErr: true})
+	var pathErr *UploadPathError
+	if !errors.As(err, &pathErr) || len(pathErr.Files) != 20 || len(drive.uploads) != 20 {
+		t.Fatalf("expected all files failed, got: %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest {
+		t.Fatalf("expected wrapped APIError, got: %v", err)
+	}
+	if failed := report.Failed(); len(failed) != 20 || failed[0].Error == "" {
+		t.Fatalf("unexpected report: %+v", report.Counts)
+	}
+
+	drive.uploads = nil
+	_, err = client.UploadPath(OneStepUploadPathReq{LocalPath: root, RemotePath: "/", Concurrency: 2})
+	if !errors.As(err, &pathErr) || len(drive.uploads) >= 20 {
+		t.Fatalf("expected upload to stop after failure, got: %v, uploads: %d", err, len(drive.uploads))
+	}
+}
+
+func TestUploadReport(t *testing.T) {
+	drive := newFakeDrive(4)
+	defer drive.Close()
+
+	root := t.TempDir()
+	for name, content := range map[string]string{"a.txt": "0123456789", "b.txt": "rapid", "c.tmp": "skip", "d.txt": "exists"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			panic(err)
+		}
+	}
+	drive.files = []File{{Fid: "exists", FileName: "d.txt", Size: 6, File: true}}
+	drive.rapidNames = map[string]bool{"b.txt": true}
+	report, err := drive.client().UploadPath(OneStepUploadPathReq{
+		LocalPath:        root,
+		RemotePath:       "/",
+		IgnoreExtensions: []string{".tmp"},
+		ConflictPolicy:   ConflictSkip,
+	})
+	if err != nil {
+		panic(err)
+	}
+	raw, err := json.Marshal(report)
+	if err != nil {
+		panic(err)
+	}
+	var decoded UploadReport
+	if err = json.Unmarshal(raw, &decoded); err != nil {
+		panic(err)
+	}
+	expected := []struct {
+		name    string
+		outcome UploadOutcome
+		bytes   int64
+		fid     string
+	}{
+		{"a.txt", OutcomeUploaded, 10, "fid"},
+		{"b.txt", OutcomeRapidUploaded, 0, "fid"},
+		{"c.tmp", OutcomeSkippedByFilter, 0, ""},
+		{"d.txt", OutcomeSkippedExisting, 0, ""},
+	}
+	if len(decoded.Files) != len(expected) || decoded.Bytes != 10 || decoded.FinishedAt.Before(decoded.StartedAt) {
+		t.Fatalf("unexpected report: %s", raw)
+	}
+	for i, e := range expected {
+		entry := decoded.Files[i]
+		if filepath.Base(entry.Path) != e.name || entry.Outcome != e.outcome || entry.Bytes != e.bytes || entry.Fid != e.fid {
+			t.Fatalf("unexpected entry %d: %+v", i, entry)
+		}
+	}
+	if decoded.Files[0].RemotePath != "/a.txt" || decoded.Files[2].Reason == "" {
+		t.Fatalf("unexpected report: %s", raw)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	drive := newFakeDrive(4)
+	defer drive.Close()
+
+	root := t.TempDir()
+	for _, name := range []string{"a.txt", "sub/b.txt", "sub/c.txt"} {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			panic(err)
+		}
+		if err := os.WriteFile(full, []byte(name), 0644); err != nil {
+			panic(err)
+		}
+	}
+	drive.files = []File{{Fid: "exists", FileName: "a.txt", Size: 1, File: true}}
+	client := drive.client()
+	report, err := client.UploadPath(OneStepUploadPathReq{
+		LocalPath:      root,
+		RemotePath:     "/",
+		SuccessDel:     true,
+		ConflictPolicy: ConflictOverwrite,
+		DryRun:         true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if drive.pres != 0 || len(drive.dirs) != 0 {
+		t.Fatalf("mutating request sent in dry run, pre: %d, dirs: %v", drive.pres, drive.dirs)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt", "sub/c.txt"} {
+		if _, err = os.Stat(filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Fatalf("local file removed in dry run: %v", err)
+		}
+	}
+	counts := make(map[PlannedOpType]int)
+	for _, op := range report.Planned {
+		counts[op.Type]++
+	}
+	if counts[PlanUpload] != 3 || counts[PlanMakeDir] != 1 || counts[PlanDelete] != 1 || counts[PlanDeleteLocal] != 3 {
+		t.Fatalf("unexpected planned operations: %+v", report.Planned)
+	}
+	if report.Counts[OutcomeDryRun] != 3 || report.Files[1].RemotePath != "/sub/b.txt" {
+		t.Fatalf("unexpected report: %+v", report.Files)
+	}
+
+	// 演练计划的目录不影响之后的实际上传
+	localFile := filepath.Join(root, "a.txt")
+	if err = client.UploadFile(OneStepUploadFileReq{LocalFile: localFile, RemotePath: "/newdir", DryRun: true}); err != nil {
+		panic(err)
+	}
+	if err = client.UploadFile(OneStepUploadFileReq{LocalFile: localFile, RemotePath: "/newdir"}); err != nil {
+		panic(err)
+	}
+	if len(drive.dirs["0"]) != 1 || strings.Join(drive.uploads, ",") != "newdir/a.txt" {
+		t.Fatalf("real upload used planned directory, dirs: %v, uploads: %v", drive.dirs, drive.uploads)
+	}
+
+	// 演练时 ConflictSkip 同样比较MD5，大小相同但内容不同的文件计划上传
+	drive.files = []File{{Fid: "exists", FileName: "a.txt", Size: 5, Md5: md5Hash("other"), File: true}}
+	report, err = client.UploadPath(OneStepUploadPathReq{
+		LocalPath:      root,
+		RemotePath:     "/",
+		ConflictPolicy: ConflictSkip,
+		DryRun:         true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if report.Files[0].Path != localFile || report.Files[0].Outcome != OutcomeDryRun {
+		t.Fatalf("expected planned upload on md5 mismatch, got: %+v", report.Files[0])
+	}
+
+	var planned []PlannedOperation
+	client = drive.client(WithDryRun(func(op PlannedOperation) {
+		planned = append(planned, op)
+	}))
+	if err = client.FileDelete([]string{"f1"}); err != nil {
+		panic(err)
+	}
+	if err = client.FileMove([]string{"f2"}, "dst"); err != nil {
+		panic(err)
+	}
+	if _, err = client.ShareFile(ShareReq{FidList: []string{"f3"}, Title: "share"}); err != nil {
+		t.Fatalf("share in dry run: %v", err)
+	}
+	if len(planned) != 3 || planned[0].Type != PlanDelete || planned[1].Type != PlanMove || planned[1].Target != "dst" || planned[2].Type != PlanShare {
+		t.Fatalf("unexpected planned operations: %+v", planned)
+	}
+}
+
+func TestSuccessDelVerify(t *testing.T) {
+	drive := newFakeDrive(1024)
+	defer drive.Close()
+
+	root := filepath.Join(t.TempDir(), "root")
+	trash := filepath.Join(t.TempDir(), "trash")
+	for _, name := range []string{"b.txt", "sub/deep/a.txt"} {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			panic(err)
+		}
+		if err := os.WriteFile(full, []byte(name), 0644); err != nil {
+			panic(err)
+		}
+	}
+	client := drive.client()
+	_, err := client.UploadPath(OneStepUploadPathReq{
+		LocalPath:       root,
+		RemotePath:      "/",
+		SuccessDel:      true,
+		VerifyBeforeDel: true,
+		TrashDir:        trash,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if entries, err := os.ReadDir(root); err != nil || len(entries) != 0 {
+		t.Fatalf("expected empty root kept, got: %v %v", entries, err)
+	}
+	for _, name := range []string{"b.txt", "sub/deep/a.txt"} {
+		if content, err := os.ReadFile(filepath.Join(trash, filepath.FromSlash(name))); err != nil || string(content) != name {
+			t.Fatalf("file not moved to trash: %s %v", name, err)
+		}
+	}
+
+	localFile := filepath.Join(root, "c.txt")
+	if err = os.WriteFile(localFile, []byte("c"), 0644); err != nil {
+		panic(err)
+	}
+	drive.badMd5 = true
+	err = client.UploadFile(OneStepUploadFileReq{
+		LocalFile:       localFile,
+		RemotePath:      "/",
+		SuccessDel:      true,
+		VerifyBeforeDel: true,
+	})
+	if !errors.Is(err, ErrVerifyFailed) {
+		t.Fatalf("expected ErrVerifyFailed, got: %v", err)
+	}
+	if _, err = os.Stat(localFile); err != nil {
+		t.Fatalf("local file removed after failed verification: %v", err)
+	}
+}
+
+func TestRemoveLocalConcurrent(t *testing.T) {
+	trash := filepath.Join(t.TempDir(), "trash")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		localFile := filepath.Join(t.TempDir(), "same.txt")
+		if err := os.WriteFile(localFile, []byte(strconv.Itoa(i)), 0644); err != nil {
+			panic(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := removeLocal(localFile, trash); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	entries, err := os.ReadDir(trash)
+	if err != nil {
+		panic(err)
+	}
+	contents := make(map[string]bool)
+	for _, entry := range entries {
+		data, _ := os.ReadFile(filepath.Join(trash, entry.Name()))
+		contents[string(data)] = true
+	}
+	if len(entries) != 8 || len(contents) != 8 {
+		t.Fatalf("trashed files overwritten: %d files, %d distinct", len(entries), len(contents))
+	}
+}
+
+func TestUploadPathMissing(t *testing.T) {
+	drive := newFakeDrive(1024)
+	defer drive.Close()
+
+	before := runtime.NumGoroutine()
+	_, err := drive.client().UploadPath(OneStepUploadPathReq{
+		LocalPath:   filepath.Join(t.TempDir(), "missing"),
+		RemotePath:  "/",
+		Concurrency: 8,
+	})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected not exist, got: %v", err)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("goroutines leaked: %d before, %d after", before, after)
+	}
+}
+
+func TestUploadPathSymlinks(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.txt", "sub/b.txt", "secret.txt"} {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			panic(err)
+		}
+		if err := os.WriteFile(full, []byte(name), 0644); err != nil {
+			panic(err)
+		}
+	}
+	if err := os.Symlink("a.txt", filepath.Join(root, "link.txt")); err != nil {
+		t.Skip("symlink not supported:", err)
+	}
+	for link, target := range map[string]string{"dirlink": "sub", "sub/up": ".."} {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(link))); err != nil {
+			panic(err)
+		}
+	}
+	listener, err := net.Listen("unix", filepath.Join(root, "sock"))
+	if err != nil {
+		t.Skip("unix socket not supported:", err)
+	}
+	defer listener.Close()
+	// root 不受文件权限限制
+	unreadable := os.Geteuid() != 0
+	if unreadable {
+		if err = os.Chmod(filepath.Join(root, "secret.txt"), 0); err != nil {
+			panic(err)
+		}
+	}
+
+	tests := []struct {
+		policy   SymlinkPolicy
+		uploads  []string
+		outcomes map[string]UploadOutcome
+	}{
+		{
+			SymlinkUploadTarget,
+			[]string{"0/a.txt", "0/link.txt", "sub/b.txt"},
+			map[string]UploadOutcome{"dirlink": OutcomeSkippedUnsupported, "sub/up": OutcomeSkippedUnsupported},
+		},
+		{
+			SymlinkFollow,
+			[]string{"0/a.txt", "0/link.txt", "dirlink/b.txt", "sub/b.txt"},
+			map[string]UploadOutcome{"dirlink/up": OutcomeSkippedUnsupported, "sub/up": OutcomeSkippedUnsupported},
+		},
+		{
+			SymlinkSkip,
+			[]string{"0/a.txt", "sub/b.txt"},
+			map[string]UploadOutcome{"link.txt": OutcomeSkippedUnsupported, "dirlink": OutcomeSkippedUnsupported},
+		},
+		{
+			SymlinkAsFile,
+			[]string{"0/a.txt", "0/dirlink.symlink", "0/link.txt.symlink", "sub/b.txt", "sub/up.symlink"},
+			map[string]UploadOutcome{"link.txt": OutcomeUploaded},
+		},
+	}
+	for _, test := range tests {
+		drive := newFakeDrive(1024)
+		report, err := drive.client().UploadPath(OneStepUploadPathReq{
+			LocalPath:  root,
+			RemotePath: "/",
+			Symlinks:   test.policy,
+		})
+		drive.Close()
+		if unreadable {
+			var pathErr *UploadPathError
+			if !errors.As(err, &pathErr) || len(pathErr.Files) != 1 || !errors.Is(err, os.ErrPermission) {
+				t.Fatalf("policy %d: expected permission error, got: %v", test.policy, err)
+			}
+		} else if err != nil {
+			panic(err)
+		} else {
+			test.uploads = append(test.uploads, "0/secret.txt")
+		}
+		sort.Strings(test.uploads)
+		sort.Strings(drive.uploads)
+		if strings.Join(drive.uploads, ",") != strings.Join(test.uploads, ",") {
+			t.Fatalf("policy %d: unexpected uploads: %v", test.policy, drive.uploads)
+		}
+		test.outcomes["sock"] = OutcomeSkippedUnsupported
+		outcomes := make(map[string]UploadOutcome)
+		for _, entry := range report.Files {
+			rel, _ := filepath.Rel(root, entry.Path)
+			outcomes[filepath.ToSlash(rel)] = entry.Outcome
+		}
+		for name, outcome := range test.outcomes {
+			if outcomes[name] != outcome {
+				t.Fatalf("policy %d: %s expected %s, got: %v", test.policy, name, outcome, outcomes)
+			}
+		}
+	}
+}
+
+func TestUploadPathRelative(t *testing.T) {
+	base := t.TempDir()
+	data := filepath.Join(base, "data")
+	for _, name := range []string{"a.txt", "sub/f.txt"} {
+		full := filepath.Join(data, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			panic(err)
+		}
+		if err := os.WriteFile(full, []byte(name), 0644); err != nil {
+			panic(err)
+		}
+	}
+	// 链接指向根目录的绝对路径，遍历的路径为相对路径时也要识别为循环
+	if err := os.Symlink(data, filepath.Join(data, "sub", "loop")); err != nil {
+		t.Skip("symlink not supported:", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, tc := range []struct{ dir, localPath string }{{data, "."}, {base, "data"}} {
+		if err = os.Chdir(tc.dir); err != nil {
+			panic(err)
+		}
+		drive := newFakeDrive(1024)
+		report, err := drive.client().UploadPath(OneStepUploadPathReq{
+			LocalPath:  tc.localPath,
+			RemotePath: "/",
+			Symlinks:   SymlinkFollow,
+		})
+		drive.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.localPath, err)
+		}
+		sort.Strings(drive.uploads)
+		if strings.Join(drive.uploads, ",") != "0/a.txt,sub/f.txt" || report.Counts[OutcomeSkippedUnsupported] != 1 {
+			t.Fatalf("%s: unexpected uploads: %v, counts: %v", tc.localPath, drive.uploads, report.Counts)
+		}
+	}
+}
+
+func TestUploadTimes(t *testing.T) {
+	drive := newFakeDrive(1024)
+	defer drive.Close()
+
+	localFile := filepath.Join(t.TempDir(), "old.txt")
+	if err := os.WriteFile(localFile, []byte("old"), 0644); err != nil {
+		panic(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(localFile, mtime, mtime); err != nil {
+		panic(err)
+	}
+	client := drive.client()
+	if err := client.UploadFile(OneStepUploadFileReq{LocalFile: localFile, RemotePath: "/"}); err != nil {
+		panic(err)
+	}
+	if updated := drive.preBody["l_updated_at"]; updated != float64(mtime.UnixMilli()) {
+		t.Fatalf("unexpected l_updated_at: %v", updated)
+	}
+	if created, _ := drive.preBody["l_created_at"].(float64); created <= 0 || created > float64(time.Now().UnixMilli()) {
+		t.Fatalf("unexpected l_created_at: %v", created)
+	}
+
+	created := mtime.Add(-time.Hour)
+	_, err := client.UploadReader(context.Background(), strings.NewReader("stream"), 6, "/", "stream.txt", UploadReaderOpts{
+		ModTime:   mtime,
+		CreatedAt: created,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if drive.preBody["l_updated_at"] != float64(mtime.UnixMilli()) || drive.preBody["l_created_at"] != float64(created.UnixMilli()) {
+		t.Fatalf("unexpected times: %v", drive.preBody)
+	}
+
+	before := time.Now().UnixMilli()
+	if _, err = client.UploadReader(context.Background(), strings.NewReader("now"), 3, "/", "now.txt", UploadReaderOpts{}); err != nil {
+		panic(err)
+	}
+	if updated, _ := drive.preBody["l_updated_at"].(float64); updated < float64(before) || drive.preBody["l_created_at"] != updated {
+		t.Fatalf("unexpected default times: %v", drive.preBody)
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	limiter := newBandwidthLimiter(100, []BandwidthWindow{
+		{Start: 9 * time.Hour, End: 18 * time.Hour, Limit: 2 << 20},
+		{Start: 22 * time.Hour, End: 6 * time.Hour, Limit: 0},
+	})
+	for clock, expected := range map[string]int64{"08:59": 100, "09:00": 2 << 20, "17:59": 2 << 20, "18:00": 100, "23:30": 0, "05:00": 0} {
+		now, _ := time.ParseInLocation("15:04", clock, time.Local)
+		limiter.now = func() time.Time { return now }
+		if rate := limiter.rate(); rate != expected {
+			t.Fatalf("%s: expected %d, got %d", clock, expected, rate)
+		}
+	}
+
+	drive := newFakeDrive(20 * 1024)
+	defer drive.Close()
+	client := drive.client(WithBandwidthLimit(20 * 1024))
+	content := strings.Repeat("x", 40*1024)
+	start := time.Now()
+	if _, err := client.UploadReader(context.Background(), strings.NewReader(content), int64(len(content)), "/", "limited.txt", UploadReaderOpts{}); err != nil {
+		panic(err)
+	}
+	// 桶容量为1秒的额度，剩余20KB需要约1秒
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Fatalf("bandwidth limit not applied, elapsed: %s", elapsed)
+	}
+
+	client.SetBandwidthLimit(0)
+	if client.BandwidthLimit() != 0 {
+		t.Fatalf("unexpected limit: %d", client.BandwidthLimit())
+	}
+	start = time.Now()
+	if _, err := client.UploadReader(context.Background(), strings.NewReader(content), int64(len(content)), "/", "unlimited.txt", UploadReaderOpts{}); err != nil {
+		panic(err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("bandwidth limit not removed, elapsed: %s", elapsed)
+	}
+}
+
+func TestDownloadSegments(t *testing.T) {
+	drive := newFakeDrive(1024)
+	defer drive.Close()
+	drive.download = make([]byte, 100)
+	for i := range drive.download {
+		drive.download[i] = byte(i)
+	}
+	object := File{Fid: "fid", FileName: "data.bin", Size: len(drive.download), File: true}
+	client := drive.client()
+
+	localPath, tempDir := t.TempDir(), t.TempDir()
+	err := client.Download(OneStepDownloadFileReq{
+		Object:      object,
+		LocalPath:   localPath,
+		Concurrency: 3,
+		SegmentSize: 16,
+		TempDir:     tempDir,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(localPath, "data.bin")); err != nil || string(content) != string(drive.download) {
+		t.Fatalf("unexpected content: %v %v", content, err)
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 || len(drive.ranges) != 7 {
+		t.Fatalf("unexpected temp files %v or ranges %v", entries, drive.ranges)
+	}
+
+	// 第4个分段失败后重新下载，只请求未完成的分段
+	localPath = t.TempDir()
+	drive.ranges = nil
+	drive.failRange = "bytes=48-63"
+	req := OneStepDownloadFileReq{Object: object, LocalPath: localPath, SegmentSize: 16, TempDir: tempDir}
+	if err = client.Download(req); err == nil {
+		t.Fatal("expected download error")
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 1 {
+		t.Fatalf("expected temp file kept, got: %v", entries)
+	}
+	drive.ranges = nil
+	drive.failRange = ""
+	if err = client.Download(req); err != nil {
+		panic(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(localPath, "data.bin")); err != nil || string(content) != string(drive.download) {
+		t.Fatalf("unexpected content: %v %v", content, err)
+	}
+	if strings.Join(drive.ranges, ",") != "bytes=48-63,bytes=64-79,bytes=80-95,bytes=96-99" {
+		t.Fatalf("unexpected ranges: %v", drive.ranges)
+	}
+
+	// 错误响应体不会写入临时文件
+	localPath = t.TempDir()
+	drive.failRange = "bytes=0-15"
+	req = OneStepDownloadFileReq{Object: object, LocalPath: localPath, SegmentSize: 16, TempDir: t.TempDir()}
+	var apiErr *APIError
+	if err = client.Download(req); !errors.As(err, &apiErr) || apiErr.OSSCode != "AccessDenied" {
+		t.Fatalf("expected access denied, got: %v", err)
+	}
+	if !errors.Is(err, ErrAccessDenied) || errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("oss access denied classified as %v", apiErr.Unwrap())
+	}
+	entries, _ := os.ReadDir(req.TempDir)
+	if len(entries) != 1 {
+		t.Fatalf("expected temp file kept, got: %v", entries)
+	}
+	if head, _ := os.ReadFile(filepath.Join(req.TempDir, entries[0].Name())); strings.Contains(string(head), "AccessDenied") {
+		t.Fatal("error body written into temp file")
+	}
+
+	// 服务端忽略Range时改为单个分段下载
+	localPath = t.TempDir()
+	drive.failRange = ""
+	drive.ignoreRange = true
+	drive.ranges = nil
+	req = OneStepDownloadFileReq{Object: object, LocalPath: localPath, Concurrency: 3, SegmentSize: 16, TempDir: t.TempDir()}
+	if err = client.Download(req); err != nil {
+		panic(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(localPath, "data.bin")); err != nil || string(content) != string(drive.download) {
+		t.Fatalf("unexpected content: %v %v", content, err)
+	}
+	if last := drive.ranges[len(drive.ranges)-1]; last != "bytes=0-99" {
+		t.Fatalf("expected single stream fallback, got: %v", drive.ranges)
+	}
+
+	// 传入的大小与下载接口不一致时不下载
+	drive.ignoreRange = false
+	drive.ranges = nil
+	stale := object
+	stale.Size = 50
+	if err = client.Download(OneStepDownloadFileReq{Object: stale, LocalPath: t.TempDir()}); !errors.Is(err, ErrFileChanged) || len(drive.ranges) != 0 {
+		t.Fatalf("expected file changed without download, got: %v %v", err, drive.ranges)
+	}
+	// 未传入大小时以下载接口为准
+	localPath = t.TempDir()
+	if err = client.Download(OneStepDownloadFileReq{Object: File{Fid: "fid", FileName: "data.bin"}, LocalPath: localPath, SegmentSize: 16}); err != nil {
+		panic(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(localPath, "data.bin")); err != nil || string(content) != string(drive.download) {
+		t.Fatalf("unexpected content: %v %v", content, err)
+	}
+}
+
+func TestDownloadVerify(t *testing.T) {
+	drive := newFakeDrive(1024)
+	defer drive.Close()
+	drive.download = []byte(strings.Repeat("0123456789", 10))
+	object := File{Fid: "fid", FileName: "data.bin", Size: len(drive.download), File: true}
+	client := drive.client(WithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
+
+	// 响应体不完整时重试该分段，进度按实际写入的字节数计算
+	localPath := t.TempDir()
+	drive.shortRange = "bytes=32-63"
+	var finished ProgressEvent
+	err := client.Download(OneStepDownloadFileReq{
+		Object:      object,
+		LocalPath:   localPath,
+		SegmentSize: 32,
+		Progress: ProgressFunc(func(event ProgressEvent) {
+			if event.Type == EventFileFinished {
+				finished = event
+			}
+		}),
+	})
+	if err != nil {
+		panic(err)
+	}
+	if finished.Transferred != int64(len(drive.download)) || len(drive.ranges) != 5 {
+		t.Fatalf("unexpected progress %d or ranges %v", finished.Transferred, drive.ranges)
+	}
+
+	localPath, quarantine := t.TempDir(), t.TempDir()
+	drive.ranges = nil
+	drive.badMd5 = true
+	err = client.Download(OneStepDownloadFileReq{
+		Object:        object,
+		LocalPath:     localPath,
+		SegmentSize:   32,
+		VerifyRetries: 1,
+		QuarantineDir: quarantine,
+	})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got: %v", err)
+	}
+	if len(drive.ranges) != 8 {
+		t.Fatalf("expected download retried once, got ranges: %v", drive.ranges)
+	}
+	if entries, _ := os.ReadDir(quarantine); len(entries) != 2 {
+		t.Fatalf("expected 2 quarantined files, got: %v", entries)
+	}
+	if entries, _ := os.ReadDir(localPath); len(entries) != 0 {
+		t.Fatalf("expected no files left, got: %v", entries)
+	}
+}
+
+func TestProgressEvents(t *testing.T) {
+	interval := progressInterval
+	progressInterval = 0
+	defer func() { progressInterval = interval }()
+	drive := newFakeDrive(4)
+	defer drive.Close()
+	drive.retryPart = 2
+
+	localFile := filepath.Join(t.TempDir(), "progress.txt")
+	if err := os.WriteFile(localFile, []byte("0123456789"), 0644); err != nil {
+		panic(err)
+	}
+	var (
+		mu     sync.Mutex
+		events []ProgressEvent
+	)
+	client := drive.client(WithRetryPolicy(RetryPolicy{MaxAttempts: 2, RetryStatus: []int{http.StatusServiceUnavailable}}))
+	err := client.UploadFile(OneStepUploadFileReq{
+		LocalFile:       localFile,
+		RemotePath:      "/",
+		PartConcurrency: 3,
+		Progress: ProgressFunc(func(event ProgressEvent) {
+			mu.Lock()
+			events = append(events, event)
+			mu.Unlock()
+		}),
+	})
+	if err != nil {
+		panic(err)
+	}
+	first, last := events[0], events[len(events)-1]
+	if first.Type != EventFileStarted || first.Transferred != 0 || first.Total != 10 {
+		t.Fatalf("unexpected first event: %+v", first)
+	}
+	if last.Type != EventFileFinished || last.Transferred != 10 || last.Fid != "fid" {
+		t.Fatalf("unexpected last event: %+v", last)
+	}
+	parts := make(map[int]int)
+	rewound := false
+	var previous int64
+	for _, event := range events[1 : len(events)-1] {
+		switch event.Type {
+		case EventPartCompleted:
+			parts[event.PartNumber]++
+		case EventBytesTransferred:
+			if event.Transferred < 0 || event.Transferred > event.Total {
+				t.Fatalf("unexpected transferred: %+v", event)
+			}
+			rewound = rewound || event.Transferred < previous
+			previous = event.Transferred
+		default:
+			t.Fatalf("unexpected event: %+v", event)
+		}
+	}
+	if len(parts) != 3 || parts[1] != 1 || parts[2] != 1 || parts[3] != 1 {
+		t.Fatalf("unexpected completed parts: %v", parts)
+	}
+	if !rewound {
+		t.Fatal("expected progress rewind on part retry")
+	}
+}
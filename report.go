@@ -0,0 +1,109 @@
+package quark
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// UploadOutcome 单个文件的上传结果
+type UploadOutcome string
+
+const (
+	// OutcomeUploaded 上传成功
+	OutcomeUploaded UploadOutcome = "uploaded"
+	// OutcomeRapidUploaded 秒传成功，没有传输数据
+	OutcomeRapidUploaded UploadOutcome = "rapid_uploaded"
+	// OutcomeRapidMiss RapidOnly 时秒传失败，没有上传
+	OutcomeRapidMiss UploadOutcome = "rapid_miss"
+	// OutcomeSkippedByFilter 被过滤规则跳过，Reason 为命中的规则
+	OutcomeSkippedByFilter UploadOutcome = "skipped_by_filter"
+	// OutcomeSkippedUnsupported 特殊文件、被跳过的符号链接等无法上传的文件，Reason 为文件类型
+	OutcomeSkippedUnsupported UploadOutcome = "skipped_unsupported"
+	// OutcomeSkippedExisting ConflictSkip 时网盘已存在相同的文件
+	OutcomeSkippedExisting UploadOutcome = "skipped_existing"
+	// OutcomeFailed 上传失败，Error 为失败原因
+	OutcomeFailed UploadOutcome = "failed"
+	// OutcomeDryRun 演练模式下计划上传，没有发送请求
+	OutcomeDryRun UploadOutcome = "dry_run"
+)
+
+// UploadReportEntry 单个文件或被跳过的目录的上传结果
+type UploadReportEntry struct {
+	Path       string        `json:"path"`
+	RemotePath string        `json:"remote_path,omitempty"`
+	Dir        bool          `json:"dir,omitempty"`
+	Outcome    UploadOutcome `json:"outcome"`
+	Reason     string        `json:"reason,omitempty"`
+	Size       int64         `json:"size"`
+	// Bytes 本次实际传输的字节数，秒传和断点续传前已完成的部分不计入
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration"`
+	Fid      string        `json:"fid,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Err      error         `json:"-"`
+}
+
+// UploadReport UploadPath 的上传报告，Files 按路径排序，可直接序列化为JSON
+type UploadReport struct {
+	LocalPath  string    `json:"local_path"`
+	RemotePath string    `json:"remote_path"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Counts 各结果的文件数
+	Counts map[UploadOutcome]int `json:"counts"`
+	// Bytes 本次实际传输的总字节数
+	Bytes int64               `json:"bytes"`
+	Files []UploadReportEntry `json:"files"`
+	// Planned 演练模式下计划执行的修改操作，按发生顺序排列
+	Planned []PlannedOperation `json:"planned,omitempty"`
+
+	mu sync.Mutex
+}
+
+func newUploadReport(req OneStepUploadPathReq) *UploadReport {
+	return &UploadReport{
+		LocalPath:  req.LocalPath,
+		RemotePath: req.RemotePath,
+		StartedAt:  time.Now(),
+		Counts:     make(map[UploadOutcome]int),
+		Files:      make([]UploadReportEntry, 0),
+	}
+}
+
+// Failed 返回上传失败的文件
+func (r *UploadReport) Failed() []UploadReportEntry {
+	failed := make([]UploadReportEntry, 0)
+	for _, entry := range r.Files {
+		if entry.Outcome == OutcomeFailed {
+			failed = append(failed, entry)
+		}
+	}
+	return failed
+}
+
+func (r *UploadReport) add(entry UploadReportEntry) {
+	if entry.Err != nil {
+		entry.Error = entry.Err.Error()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Files = append(r.Files, entry)
+	r.Counts[entry.Outcome]++
+	r.Bytes += entry.Bytes
+}
+
+func (r *UploadReport) plan(op PlannedOperation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Planned = append(r.Planned, op)
+}
+
+func (r *UploadReport) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.FinishedAt = time.Now()
+	sort.SliceStable(r.Files, func(i, j int) bool {
+		return r.Files[i].Path < r.Files[j].Path
+	})
+}
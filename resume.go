@@ -0,0 +1,229 @@
+package quark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+)
+
+// uploadSessionMaxAge 断点续传会话的最长使用时间，超过后服务端的UploadId可能已失效
+var uploadSessionMaxAge = 24 * time.Hour
+
+// uploadManifest 断点续传清单，恢复前校验本地文件和会话是否仍然有效
+type uploadManifest struct {
+	LocalFile  string    `json:"local_file"`
+	RemotePath string    `json:"remote_path"`
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	Hash       FileHash  `json:"hash"`
+	PartSize   int64     `json:"part_size"`
+	UploadId   string    `json:"upload_id"`
+	// Parts 已完成的分片，key为分片序号，value为ETag
+	Parts     map[int]string                             `json:"parts"`
+	Pre       RespDataWithMeta[FileUpPre, FileUpPreMeta] `json:"pre"`
+	CreatedAt time.Time                                  `json:"created_at"`
+	UpdatedAt time.Time                                  `json:"updated_at"`
+}
+
+func manifestKey(resumeKey string) string {
+	return "upload_" + resumeKey
+}
+
+func newUploadManifest(src uploadSource, pre RespDataWithMeta[FileUpPre, FileUpPreMeta]) *uploadManifest {
+	now := time.Now()
+	return &uploadManifest{
+		LocalFile:  src.localFile,
+		RemotePath: src.remotePath,
+		Name:       src.name,
+		Size:       src.size,
+		ModTime:    src.modTime,
+		Hash:       src.hash,
+		PartSize:   int64(pre.Metadata.PartSize),
+		UploadId:   pre.Data.UploadId,
+		Parts:      make(map[int]string),
+		Pre:        pre,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+}
+
+// validate 检查清单能否用于src的续传，不能时返回原因
+func (m *uploadManifest) validate(src uploadSource) error {
+	switch {
+	case m.Size != src.size:
+		return fmt.Errorf("size changed from %d to %d", m.Size, src.size)
+	case !m.ModTime.Equal(src.modTime):
+		return fmt.Errorf("mtime changed from %s to %s", m.ModTime, src.modTime)
+	case m.Hash != src.hash:
+		return errors.New("content hash changed")
+	case m.Name != src.name:
+		return fmt.Errorf("remote name changed from %s to %s", m.Name, src.name)
+	case m.Pre.Data.TaskId == "" || m.UploadId == "" || m.UploadId != m.Pre.Data.UploadId:
+		return errors.New("incomplete session")
+	case m.PartSize <= 0:
+		return fmt.Errorf("invalid part size: %d", m.PartSize)
+	case time.Since(m.CreatedAt) > uploadSessionMaxAge:
+		return fmt.Errorf("session created at %s is stale", m.CreatedAt)
+	}
+	partCount := int((m.Size + m.PartSize - 1) / m.PartSize)
+	for partNumber, etag := range m.Parts {
+		if partNumber < 1 || partNumber > partCount || etag == "" {
+			return fmt.Errorf("invalid part %d", partNumber)
+		}
+	}
+	return nil
+}
+
+// doneBytes 已完成分片的字节数
+func (m *uploadManifest) doneBytes() int64 {
+	var done int64
+	for partNumber := range m.Parts {
+		offset := int64(partNumber-1) * m.PartSize
+		done += min(m.PartSize, m.Size-offset)
+	}
+	return done
+}
+
+// loadManifest 读取并校验src的续传清单，不存在或无效时返回nil并清理旧的状态
+func (c *QuarkClient) loadManifest(src uploadSource) *uploadManifest {
+	key := manifestKey(src.resumeKey)
+	var m uploadManifest
+	ok, err := c.getState(key, &m)
+	if !ok {
+		c.logger.Debug("cache miss", "key", key, "path", src.tracker.path, "err", err)
+		return nil
+	}
+	if err = m.validate(src); err != nil {
+		c.logger.Info("discard resume manifest", "path", src.tracker.path, "reason", err)
+		_ = c.delState(key)
+		return nil
+	}
+	if m.Parts == nil {
+		m.Parts = make(map[int]string)
+	}
+	return &m
+}
+
+func (c *QuarkClient) saveManifest(resumeKey string, m *uploadManifest) {
+	m.UpdatedAt = time.Now()
+	if err := c.setState(manifestKey(resumeKey), m, uploadStateTTL); err != nil {
+		c.logger.Warn("cache error", "key", manifestKey(resumeKey), "path", m.LocalFile, "err", err)
+	}
+}
+
+// completedParts 返回已完成分片的副本，避免与保存清单的回调共享map
+func (m *uploadManifest) completedParts() map[int]string {
+	return maps.Clone(m.Parts)
+}
+
+// staleSession 判断续传时的错误是否由服务端会话失效引起，即OSS返回NoSuchUpload，
+// 其他404（如目录被删除）不会重新开始上传
+func staleSession(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.OSSCode == "NoSuchUpload"
+}
+
+func (c *QuarkClient) ListPendingUploads() ([]PendingUpload, error) {
+	return c.ListPendingUploadsCtx(context.Background())
+}
+
+// ListPendingUploadsCtx 列出当前账号下保存的未完成的断点续传会话
+func (c *QuarkClient) ListPendingUploadsCtx(ctx context.Context) ([]PendingUpload, error) {
+	prefix := c.stateKey(manifestKey(""))
+	keys, err := c.opts.stateStore.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]PendingUpload, 0, len(keys))
+	for _, key := range keys {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		id := strings.TrimPrefix(key, prefix)
+		var m uploadManifest
+		ok, err := c.getState(manifestKey(id), &m)
+		if err != nil {
+			c.logger.Warn("cache error", "key", key, "err", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		pending = append(pending, PendingUpload{
+			Id:         id,
+			LocalFile:  m.LocalFile,
+			RemotePath: m.RemotePath,
+			Size:       m.Size,
+			BytesDone:  m.doneBytes(),
+			Age:        time.Since(m.CreatedAt),
+			UpdatedAt:  m.UpdatedAt,
+		})
+	}
+	return pending, nil
+}
+
+func (c *QuarkClient) AbortUpload(id string) error {
+	return c.AbortUploadCtx(context.Background(), id)
+}
+
+// AbortUploadCtx 取消服务端的分片上传并删除本地保存的续传状态，
+// 服务端取消失败时保留状态以便重试
+func (c *QuarkClient) AbortUploadCtx(ctx context.Context, id string) error {
+	var m uploadManifest
+	ok, err := c.getState(manifestKey(id), &m)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w:upload %s", ErrNotFound, id)
+	}
+	if c.plan(ctx, PlannedOperation{Type: PlanAbortUpload, Path: m.LocalFile, Target: id}) {
+		return nil
+	}
+	pre := m.Pre.Data
+	if pre.UploadId != "" {
+		err = c.FileUpAbortCtx(ctx, FileUpAbortReq{
+			ObjKey:    pre.ObjKey,
+			Bucket:    pre.Bucket,
+			UploadId:  pre.UploadId,
+			AuthInfo:  pre.AuthInfo,
+			UploadUrl: pre.UploadUrl,
+			TaskId:    pre.TaskId,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	c.logger.Info("abort upload", "id", id, "path", m.LocalFile, "task_id", pre.TaskId)
+	return c.delState(manifestKey(id))
+}
+
+func (c *QuarkClient) PurgeStaleUploads(olderThan time.Duration) ([]PendingUpload, error) {
+	return c.PurgeStaleUploadsCtx(context.Background(), olderThan)
+}
+
+// PurgeStaleUploadsCtx 取消创建时间超过olderThan的续传会话，返回已清理的会话，
+// 单个会话失败不影响其他会话，所有错误合并返回
+func (c *QuarkClient) PurgeStaleUploadsCtx(ctx context.Context, olderThan time.Duration) ([]PendingUpload, error) {
+	pending, err := c.ListPendingUploadsCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	purged := make([]PendingUpload, 0)
+	var errs []error
+	for _, p := range pending {
+		if p.Age < olderThan {
+			continue
+		}
+		if err = c.AbortUploadCtx(ctx, p.Id); err != nil {
+			errs = append(errs, fmt.Errorf("abort %s: %w", p.LocalFile, err))
+			continue
+		}
+		purged = append(purged, p)
+	}
+	return purged, errors.Join(errs...)
+}
@@ -0,0 +1,85 @@
+package quark
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"slices"
+	"time"
+)
+
+// RetryPolicy 幂等请求的重试策略
+type RetryPolicy struct {
+	// MaxAttempts 最大尝试次数（包含第一次），小于等于1时不重试
+	MaxAttempts int
+	// MinBackoff 首次重试前的等待时间，之后按指数增长
+	MinBackoff time.Duration
+	// MaxBackoff 等待时间上限
+	MaxBackoff time.Duration
+	// RetryStatus 需要重试的HTTP状态码
+	RetryStatus []int
+	// RetryCodes 需要重试的夸克错误码
+	RetryCodes []int
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  time.Second,
+	MaxBackoff:  30 * time.Second,
+	RetryStatus: []int{429, 500, 502, 503, 504},
+}
+
+// retryable 判断错误是否可以重试，网络错误及命中策略的接口错误才会重试
+func (p RetryPolicy) retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return errors.Is(apiErr, ErrRateLimited) ||
+			slices.Contains(p.RetryStatus, apiErr.Status) ||
+			(apiErr.Code != 0 && slices.Contains(p.RetryCodes, apiErr.Code))
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// backoff 计算第attempt次失败后的等待时间，优先使用服务端返回的Retry-After
+func (p RetryPolicy) backoff(attempt int, err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+	d := p.MinBackoff << (attempt - 1)
+	if d <= 0 || (p.MaxBackoff > 0 && d > p.MaxBackoff) {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	// 在 [d/2, d] 之间随机，避免多个请求同时重试
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// retry 按策略执行fn，直到成功、错误不可重试或达到最大次数
+func retry[T any](ctx context.Context, policy RetryPolicy, fn func() (T, error)) (T, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := fn()
+		if err == nil || attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			return result, err
+		}
+		if sleepErr := sleepCtx(ctx, policy.backoff(attempt, err)); sleepErr != nil {
+			return result, err
+		}
+	}
+}
+
+func retryErr(ctx context.Context, policy RetryPolicy, fn func() error) error {
+	_, err := retry(ctx, policy, func() (struct{}, error) {
+		return struct{}{}, fn()
+	})
+	return err
+}
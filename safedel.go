@@ -0,0 +1,140 @@
+package quark
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// verifyRemote 比较网盘文件与本地文件的大小和MD5，网盘没有返回MD5时只比较大小
+func (c *QuarkClient) verifyRemote(ctx context.Context, fid string, size int64, md5 string) error {
+	resp, err := c.FileDownloadCtx(ctx, fid)
+	if err != nil {
+		return err
+	}
+	if len(resp.Data) == 0 {
+		return fmt.Errorf("%w: %s not found", ErrVerifyFailed, fid)
+	}
+	remote := resp.Data[0]
+	if int64(remote.Size) != size {
+		return fmt.Errorf("%w: %s size %d, local %d", ErrVerifyFailed, fid, remote.Size, size)
+	}
+	if remote.Md5 == "" {
+		c.logger.Debug("remote md5 missing, verified by size only", "fid", fid)
+		return nil
+	}
+	if md5 != "" && normalizeMd5(remote.Md5) != strings.ToLower(md5) {
+		return fmt.Errorf("%w: %s md5 %s, local %s", ErrVerifyFailed, fid, remote.Md5, md5)
+	}
+	return nil
+}
+
+// normalizeMd5 网盘返回的MD5可能是hex或base64，统一转为小写hex
+func normalizeMd5(md5 string) string {
+	if len(md5) == 24 {
+		if raw, err := base64.StdEncoding.DecodeString(md5); err == nil && len(raw) == 16 {
+			return hex.EncodeToString(raw)
+		}
+	}
+	return strings.ToLower(md5)
+}
+
+// removeLocal trashDir为空时删除本地文件，否则移动到trashDir下，已存在同名文件时自动重命名
+func removeLocal(localFile, trashDir string) (string, error) {
+	if trashDir == "" {
+		return "", os.Remove(localFile)
+	}
+	if err := os.MkdirAll(trashDir, 0755); err != nil {
+		return "", err
+	}
+	name := filepath.Base(localFile)
+	ext := filepath.Ext(name)
+	target := filepath.Join(trashDir, name)
+	// 以O_EXCL创建占位文件预留文件名，并发移动同名文件时不会互相覆盖
+	for i := 1; ; i++ {
+		placeholder, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			_ = placeholder.Close()
+			break
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		target = filepath.Join(trashDir, fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext))
+	}
+	if err := os.Rename(localFile, target); err == nil {
+		return target, nil
+	}
+	// 跨磁盘时无法rename，复制后再删除
+	if err := copyFile(localFile, target); err != nil {
+		_ = os.Remove(target)
+		return "", err
+	}
+	return target, os.Remove(localFile)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	// dst为预留的占位文件时权限需要与src一致
+	if err = out.Chmod(stat.Mode().Perm()); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, stat.ModTime(), stat.ModTime())
+}
+
+// cleanEmptyParents 从dir开始逐级向上删除空目录，遇到非空目录或到达root时停止，root本身不会被删除
+func cleanEmptyParents(dir, root string) []string {
+	removed := make([]string, 0)
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return removed
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return removed
+	}
+	for {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return removed
+		}
+		if empty, err := isEmpty(dir); err != nil || !empty {
+			return removed
+		}
+		if err = os.Remove(dir); err != nil {
+			return removed
+		}
+		removed = append(removed, dir)
+		dir = filepath.Dir(dir)
+	}
+}
+
+func sameFile(path, abs string) bool {
+	p, err := filepath.Abs(path)
+	return err == nil && p == abs
+}
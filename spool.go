@@ -0,0 +1,75 @@
+package quark
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// defaultSpoolMemory 流式上传时在内存中缓存的上限
+var defaultSpoolMemory int64 = 32 * 1024 * 1024
+
+// spool 将流完整读出，不超过maxMemory时缓存在内存，否则写入dir下的临时文件，
+// 读取时同时计算哈希，返回实际的大小，cleanup用于删除临时文件。size为负数时表示未知，读取到EOF并总是写入临时文件
+func spool(ctx context.Context, r io.Reader, size, maxMemory int64, dir string) (io.ReaderAt, int64, FileHash, func(), error) {
+	md5Hasher := md5.New()
+	sha1Hasher := sha1.New()
+	if size >= 0 {
+		r = io.LimitReader(r, size)
+	}
+	src := io.TeeReader(&ctxReader{ctx: ctx, r: r}, io.MultiWriter(md5Hasher, sha1Hasher))
+	cleanup := func() {}
+
+	var readerAt io.ReaderAt
+	var n int64
+	var err error
+	if size >= 0 && size <= maxMemory {
+		buf := bytes.NewBuffer(make([]byte, 0, size))
+		n, err = io.Copy(buf, src)
+		readerAt = bytes.NewReader(buf.Bytes())
+	} else {
+		var file *os.File
+		file, err = os.CreateTemp(dir, "quark-spool-*")
+		if err != nil {
+			return nil, 0, FileHash{}, cleanup, err
+		}
+		cleanup = func() {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+		n, err = io.Copy(file, src)
+		readerAt = file
+	}
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("spool: expected %d bytes, got %d: %w", size, n, io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		cleanup()
+		return nil, 0, FileHash{}, func() {}, err
+	}
+	hash := FileHash{
+		Md5:  hex.EncodeToString(md5Hasher.Sum(nil)),
+		Sha1: hex.EncodeToString(sha1Hasher.Sum(nil)),
+	}
+	return readerAt, n, hash, cleanup, nil
+}
+
+// readerAtHash 读取一次 io.ReaderAt 计算哈希
+func readerAtHash(ctx context.Context, r io.ReaderAt, size int64) (FileHash, error) {
+	md5Hasher := md5.New()
+	sha1Hasher := sha1.New()
+	buffer := make([]byte, 1024*1024) // 1MB buffer
+	_, err := io.CopyBuffer(io.MultiWriter(md5Hasher, sha1Hasher), &ctxReader{ctx: ctx, r: io.NewSectionReader(r, 0, size)}, buffer)
+	if err != nil {
+		return FileHash{}, err
+	}
+	return FileHash{
+		Md5:  hex.EncodeToString(md5Hasher.Sum(nil)),
+		Sha1: hex.EncodeToString(sha1Hasher.Sum(nil)),
+	}, nil
+}
@@ -0,0 +1,157 @@
+package quark
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StateStore 保存断点续传等本地状态，实现需要保证并发安全
+type StateStore interface {
+	// Get 读取key的值，不存在或已过期时ok为false
+	Get(key string) (value []byte, ok bool, err error)
+	// Set 写入key的值，ttl<=0 表示永不过期
+	Set(key string, value []byte, ttl time.Duration) error
+	// Delete 删除key，key不存在时不返回错误
+	Delete(key string) error
+	// List 返回以prefix开头且未过期的key
+	List(prefix string) ([]string, error)
+}
+
+// uploadStateTTL 断点续传状态的有效期，过期后重新上传
+var uploadStateTTL = 7 * 24 * time.Hour
+
+// stateEntry 带过期时间的值，用于持久化
+type stateEntry struct {
+	Value  []byte    `json:"value"`
+	Expire time.Time `json:"expire,omitempty"`
+}
+
+func newStateEntry(value []byte, ttl time.Duration) stateEntry {
+	entry := stateEntry{Value: value}
+	if ttl > 0 {
+		entry.Expire = time.Now().Add(ttl)
+	}
+	return entry
+}
+
+func (e stateEntry) expired() bool {
+	return !e.Expire.IsZero() && time.Now().After(e.Expire)
+}
+
+// defaultStateStore 默认保存在用户缓存目录下，重启后不会被清理
+func defaultStateStore() StateStore {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return NewDiskvStore(filepath.Join(dir, "quark-client"))
+}
+
+// memoryStore 内存中的 StateStore，进程退出后状态丢失
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string]stateEntry
+}
+
+// NewMemoryStore 创建内存中的 StateStore，适合测试或不需要跨进程续传的场景
+func NewMemoryStore() StateStore {
+	return &memoryStore{entries: make(map[string]stateEntry)}
+}
+
+func (s *memoryStore) Get(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if entry.expired() {
+		delete(s.entries, key)
+		return nil, false, nil
+	}
+	return entry.Value, true, nil
+}
+
+func (s *memoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = newStateEntry(append([]byte(nil), value...), ttl)
+	return nil
+}
+
+func (s *memoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *memoryStore) List(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0)
+	for key, entry := range s.entries {
+		if strings.HasPrefix(key, prefix) && !entry.expired() {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// accountAliasTTL 账号别名的有效期，不短于任何状态的有效期
+var accountAliasTTL = 30 * 24 * time.Hour
+
+func accountAliasKey(pus string) string {
+	return "account_" + md5Hash(pus)
+}
+
+// resolveAccount 返回pus对应的账号前缀，pus刷新过时沿用刷新前记录的账号，否则由pus计算
+func resolveAccount(store StateStore, pus string) string {
+	if account, ok, err := store.Get(accountAliasKey(pus)); err == nil && ok && len(account) > 0 {
+		return string(account)
+	}
+	return md5Hash(pus)[:16]
+}
+
+// rememberAccount 记录刷新后的pus对应的账号，使用新pus创建的客户端仍能读取原来的状态
+func (c *QuarkClient) rememberAccount(pus string) {
+	if err := c.opts.stateStore.Set(accountAliasKey(pus), []byte(c.opts.account), accountAliasTTL); err != nil {
+		c.logger.Warn("cache error", "key", accountAliasKey(pus), "err", err)
+	}
+}
+
+// stateKey 为key加上账号前缀，避免不同账号的状态互相覆盖
+func (c *QuarkClient) stateKey(key string) string {
+	return c.opts.account + "_" + key
+}
+
+// getState 读取当前账号下key的值并JSON解码到val，不存在时返回false
+func (c *QuarkClient) getState(key string, val any) (bool, error) {
+	raw, ok, err := c.opts.stateStore.Get(c.stateKey(key))
+	if err != nil || !ok {
+		return false, err
+	}
+	if err = json.Unmarshal(raw, val); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// setState JSON编码val后写入当前账号下的key
+func (c *QuarkClient) setState(key string, val any, ttl time.Duration) error {
+	raw, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return c.opts.stateStore.Set(c.stateKey(key), raw, ttl)
+}
+
+func (c *QuarkClient) delState(key string) error {
+	return c.opts.stateStore.Delete(c.stateKey(key))
+}
@@ -0,0 +1,84 @@
+package quark
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy UploadPath 遇到符号链接时的处理方式
+type SymlinkPolicy int
+
+const (
+	// SymlinkUploadTarget 指向文件的链接上传目标文件的内容，指向目录的链接跳过
+	SymlinkUploadTarget SymlinkPolicy = iota
+	// SymlinkFollow 同 SymlinkUploadTarget，并遍历指向的目录，指向上级目录的循环链接跳过
+	SymlinkFollow
+	// SymlinkSkip 跳过所有链接
+	SymlinkSkip
+	// SymlinkAsFile 把链接保存为 <name>.symlink 文件，内容为链接的目标路径
+	SymlinkAsFile
+)
+
+// symlinkFileSuffix SymlinkAsFile 时保存链接的文件名后缀
+const symlinkFileSuffix = ".symlink"
+
+// errSymlinkLoop 链接指向正在遍历的目录或其上级目录
+var errSymlinkLoop = errors.New("symlink loop")
+
+// walkTree 遍历root，回调中的路径以display代替root。policy为 SymlinkFollow 时进入指向目录的链接，
+// ancestors为外层各链接所在目录的绝对路径，链接指向其中任一目录或其上级目录时以 errSymlinkLoop 回调
+func walkTree(root, display string, policy SymlinkPolicy, ancestors []string, fn filepath.WalkFunc) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		rel, _ := filepath.Rel(root, path)
+		shown := filepath.Join(display, rel)
+		if err != nil || policy != SymlinkFollow || info.Mode()&os.ModeSymlink == 0 {
+			return fn(shown, info, err)
+		}
+		target, err := os.Stat(path)
+		if err != nil || !target.IsDir() {
+			return fn(shown, info, nil)
+		}
+		// 比较前统一转为绝对路径，LocalPath为相对路径时链接目标可能是绝对路径
+		real, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			real, err = filepath.Abs(real)
+		}
+		if err != nil {
+			return fn(shown, info, err)
+		}
+		parent, err := filepath.Abs(filepath.Dir(path))
+		if err != nil {
+			return fn(shown, info, err)
+		}
+		chain := append(ancestors[:len(ancestors):len(ancestors)], parent)
+		for _, dir := range chain {
+			if dir == real || strings.HasPrefix(dir, strings.TrimSuffix(real, string(filepath.Separator))+string(filepath.Separator)) {
+				return fn(shown, info, errSymlinkLoop)
+			}
+		}
+		err = walkTree(real, shown, policy, chain, fn)
+		if errors.Is(err, filepath.SkipDir) {
+			return nil
+		}
+		return err
+	})
+}
+
+// specialFileReason 返回不能上传的特殊文件的类型，普通文件和链接返回空字符串
+func specialFileReason(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "char device"
+	case mode&os.ModeDevice != 0:
+		return "device"
+	case mode&os.ModeIrregular != 0:
+		return "irregular file"
+	}
+	return ""
+}
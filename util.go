@@ -1,6 +1,7 @@
 package quark
 
 import (
+	"context"
 	"crypto/md5"
 	"crypto/sha1"
 	"encoding/hex"
@@ -28,40 +29,34 @@ func getMimeType(name string) string {
 	return "application/octet-stream"
 }
 
-// getFileMd5 计算本地文件的MD5值
-func getFileMd5(filename string) (string, error) {
+// getFileHash 读取一次文件同时计算MD5和SHA1
+func getFileHash(ctx context.Context, filename string) (string, string, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	defer file.Close()
-	hasher := md5.New()
+	md5Hasher := md5.New()
+	sha1Hasher := sha1.New()
 	buffer := make([]byte, 1024*1024) // 1MB buffer
-	_, err = io.CopyBuffer(hasher, file, buffer)
+	_, err = io.CopyBuffer(io.MultiWriter(md5Hasher, sha1Hasher), &ctxReader{ctx: ctx, r: file}, buffer)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	md5Bytes := hasher.Sum(nil)
-	md5Str := hex.EncodeToString(md5Bytes)
-	return md5Str, nil
+	return hex.EncodeToString(md5Hasher.Sum(nil)), hex.EncodeToString(sha1Hasher.Sum(nil)), nil
 }
 
-// getFileSha1 计算本地文件的SHA1值
-func getFileSha1(filename string) (string, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-	hasher := sha1.New()
-	buffer := make([]byte, 1024*1024) // 1MB buffer
-	_, err = io.CopyBuffer(hasher, file, buffer)
-	if err != nil {
-		return "", err
+// ctxReader 每次读取前检查ctx，便于取消耗时的读取
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
 	}
-	sha1Bytes := hasher.Sum(nil)
-	sha1Str := hex.EncodeToString(sha1Bytes)
-	return sha1Str, nil
+	return cr.r.Read(p)
 }
 
 // genRandomWord 生成一个4位随机字谜
@@ -81,3 +76,15 @@ func md5Hash(key string) string {
 	hash := md5.Sum([]byte(key))
 	return hex.EncodeToString(hash[:])
 }
+
+// sleepCtx 等待指定时长，ctx取消时提前返回
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}